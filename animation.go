@@ -0,0 +1,539 @@
+package steganography
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+// Animated images only show their first frame once decoded with image.Decode. Instead, the message is spread over all
+// frames: the stream is dealt one byte per frame in turn, in frame order, so every frame carries a share of it. Each frame
+// is embedded like a still image, palette embedding for GIF and paletted APNG frames, and sample embedding for truecolor
+// APNG frames. Frame delays, disposal and blending, and the number of loops, are kept.
+
+var (
+	// ErrInvalidAPNG is returned when the PNG data is not a valid animated PNG
+	ErrInvalidAPNG = errors.New("invalid animated PNG data")
+	// ErrUnsupportedAPNG is returned for interlaced animated PNG files, and for pixel formats frames can not be embedded in
+	ErrUnsupportedAPNG = errors.New("unsupported animated PNG format")
+	// ErrUnsupportedAnimationOption is returned when embedding in animated PNG frames is combined with options
+	// working on the whole image
+	ErrUnsupportedAnimationOption = errors.New("matrix, cost based and masked embedding are not supported with animated images")
+)
+
+// framesCarrier spreads the stream over the carriers of the frames of an animation, one byte per frame in turn
+// Frames which are full are skipped, so the capacity is the sum of the capacities of the frames.
+type framesCarrier struct {
+	frames []carrier
+}
+
+// layout returns the frame holding each of the first n bytes of the stream
+func (c framesCarrier) layout(n int) []int {
+	capacities := make([]int, len(c.frames))
+	for i, frame := range c.frames {
+		capacities[i] = frame.capacity()
+	}
+	layout := make([]int, 0, n)
+	for round := 0; len(layout) < n; round++ {
+		dealt := false
+		for i, capacity := range capacities {
+			if round < capacity && len(layout) < n {
+				layout = append(layout, i)
+				dealt = true
+			}
+		}
+		if !dealt {
+			break
+		}
+	}
+	return layout
+}
+
+func (c framesCarrier) capacity() int {
+	capacity := 0
+	for _, frame := range c.frames {
+		capacity += frame.capacity()
+	}
+	return capacity
+}
+
+func (c framesCarrier) write(data []byte) {
+	parts := make([][]byte, len(c.frames))
+	for i, frame := range c.layout(len(data)) {
+		parts[frame] = append(parts[frame], data[i])
+	}
+	for i, part := range parts {
+		if len(part) > 0 {
+			c.frames[i].write(part)
+		}
+	}
+}
+
+func (c framesCarrier) read(offset, length uint32) []byte {
+	layout := c.layout(int(offset) + int(length))
+	counts := make([]int, len(c.frames))
+	for _, frame := range layout {
+		counts[frame]++
+	}
+	parts := make([][]byte, len(c.frames))
+	for i, count := range counts {
+		if count > 0 {
+			parts[i] = c.frames[i].read(0, uint32(count))
+		}
+	}
+
+	message := make([]byte, length)
+	read := make([]int, len(c.frames))
+	for i, frame := range layout {
+		if i >= int(offset) {
+			message[i-int(offset)] = parts[frame][read[frame]]
+		}
+		read[frame]++
+	}
+	return message
+}
+
+// frameKey returns the key walking the pixels of a frame, nil when the options have no key
+// The first frame uses the key itself, so that a single frame animation carries the message like a still image.
+func frameKey(key []byte, frame int) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	if frame == 0 {
+		return key
+	}
+	b := make([]byte, len(key)+4)
+	copy(b, key)
+	binary.BigEndian.PutUint32(b[len(key):], uint32(frame))
+	return b
+}
+
+// gifCarrier returns the carrier embedding in the pixels of all frames of the animated GIF with these options
+func (opts Options) gifCarrier(g *gif.GIF) carrier {
+	frames := make([]carrier, len(g.Image))
+	for i, frame := range g.Image {
+		frames[i] = newPaletteCarrier(frame, frameKey(opts.Key, i))
+	}
+	return opts.protect(framesCarrier{frames})
+}
+
+// EmbedAnimatedGIF encodes the message across the frames of a copy of the animated GIF cover, as decoded by gif.DecodeAll
+// Each frame keeps its palette, delay and disposal, and the loop count and global color table are kept as well.
+// Options working on samples are refused with ErrUnsupportedPaletteOption, see validatePalette.
+func (e *Encoder) EmbedAnimatedGIF(cover *gif.GIF, message []byte) (*gif.GIF, error) {
+	opts := e.opts
+	if err := opts.validatePalette(); err != nil {
+		return nil, err
+	}
+	h, message, err := opts.container(message)
+	if err != nil {
+		return nil, err
+	}
+
+	stego := *cover
+	stego.Image = make([]*image.Paletted, len(cover.Image))
+	for i, frame := range cover.Image {
+		stego.Image[i] = &image.Paletted{
+			Pix:     append([]uint8{}, frame.Pix...),
+			Stride:  frame.Stride,
+			Rect:    frame.Rect,
+			Palette: frame.Palette,
+		}
+	}
+	stego.Delay = append([]int{}, cover.Delay...)
+	stego.Disposal = append([]byte{}, cover.Disposal...)
+
+	c := opts.gifCarrier(&stego)
+	if messageCapacity(c.capacity()) < uint32(h.size()-headerSize+len(message)) {
+		return nil, ErrMessageTooLarge
+	}
+	c.write(append(h.marshal(), message...))
+	return &stego, nil
+}
+
+// EncodeAnimatedGIF encodes the message across the frames of the animated GIF cover, and writes the result as GIF to w
+func (e *Encoder) EncodeAnimatedGIF(w io.Writer, cover *gif.GIF, message []byte) error {
+	stego, err := e.EmbedAnimatedGIF(cover, message)
+	if err != nil {
+		return err
+	}
+	return gif.EncodeAll(w, stego)
+}
+
+// MaxEncodeSizeAnimatedGIF returns how many bytes can be stored in the frames of the animated GIF by EmbedAnimatedGIF
+func (e *Encoder) MaxEncodeSizeAnimatedGIF(g *gif.GIF) uint32 {
+	opts := e.opts
+	if opts.validatePalette() != nil {
+		return 0
+	}
+	size, overhead := messageCapacity(opts.gifCarrier(g).capacity()), uint32(opts.overhead())
+	if size < overhead {
+		return 0
+	}
+	return size - overhead
+}
+
+// DecodeAnimatedGIF returns the message embedded by EmbedAnimatedGIF in the frames of the animated GIF, reassembled in frame order
+func (d *Decoder) DecodeAnimatedGIF(g *gif.GIF) (message []byte, err error) {
+	opts := d.opts
+	if err = opts.validatePalette(); err != nil {
+		return nil, err
+	}
+	c := opts.gifCarrier(g)
+	h, err := readHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	if h.embeddingDepth() != 1 || h.errorCorrection() != opts.ErrorCorrection {
+		return nil, ErrInvalidHeader
+	}
+	message, _, err = readMessage(c, h, opts.passphrase())
+	return message, err
+}
+
+// apngFrame is a frame of an animated PNG file
+type apngFrame struct {
+	width, height int
+	data          []byte      // zlib stream of the scanlines of the frame
+	first         int         // index of the first IDAT or fdAT chunk holding the data
+	img           image.Image // the decoded frame
+	plane         plane       // plane of truecolor frames
+}
+
+// apngFile is an animated PNG file, as its chunks and frames
+// Frames are decoded by wrapping their data in a still PNG file with the header of the animation, sized as the frame.
+type apngFile struct {
+	chunks    []pngChunk
+	frames    []*apngFrame
+	owner     []int // frame each chunk holds the data of, -1 for other chunks and the default image
+	depth     byte
+	colorType byte
+}
+
+// animatedPNG reports whether the data is an animated PNG file
+func animatedPNG(data []byte) bool {
+	chunks, _, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, chunk := range chunks {
+		if chunk.typ == "acTL" {
+			return true
+		}
+	}
+	return false
+}
+
+// readAPNG splits the animated PNG data in chunks and frames
+// Interlaced files, grayscale files with less than 8 bits per sample, files with a gray and alpha color type and
+// truecolor files with a transparent color are refused with ErrUnsupportedAPNG, as their frames could not be written back.
+func readAPNG(data []byte) (*apngFile, error) {
+	chunks, _, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if chunks[0].typ != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, ErrInvalidPNG
+	}
+	ihdr := chunks[0].data
+	f := &apngFile{chunks: chunks, owner: make([]int, len(chunks)), depth: ihdr[8], colorType: ihdr[9]}
+
+	animated, transparent := false, false
+	var frame *apngFrame
+	for i, chunk := range chunks {
+		f.owner[i] = -1
+		switch chunk.typ {
+		case "acTL":
+			animated = true
+		case "tRNS":
+			transparent = true
+		case "fcTL":
+			if len(chunk.data) != 26 {
+				return nil, ErrInvalidAPNG
+			}
+			frame = &apngFrame{
+				width:  int(binary.BigEndian.Uint32(chunk.data[4:])),
+				height: int(binary.BigEndian.Uint32(chunk.data[8:])),
+				first:  -1,
+			}
+			f.frames = append(f.frames, frame)
+		case "IDAT", "fdAT":
+			data := chunk.data
+			if chunk.typ == "fdAT" {
+				if frame == nil || len(data) < 4 {
+					return nil, ErrInvalidAPNG
+				}
+				data = data[4:] // the sequence number
+			} else if frame == nil {
+				continue // the default image, which is not part of the animation
+			} else if len(f.frames) > 1 {
+				return nil, ErrInvalidAPNG
+			}
+			if frame.first < 0 {
+				frame.first = i
+			}
+			frame.data = append(frame.data, data...)
+			f.owner[i] = len(f.frames) - 1
+		}
+	}
+	if !animated || len(f.frames) == 0 {
+		return nil, ErrInvalidAPNG
+	}
+	for _, frame := range f.frames {
+		if frame.first < 0 || frame.width <= 0 || frame.height <= 0 {
+			return nil, ErrInvalidAPNG
+		}
+	}
+
+	if ihdr[12] != 0 {
+		return nil, ErrUnsupportedAPNG
+	}
+	switch f.colorType {
+	case 3:
+	case 0, 2, 6:
+		if f.depth != 8 && f.depth != 16 || transparent {
+			return nil, ErrUnsupportedAPNG
+		}
+	default:
+		return nil, ErrUnsupportedAPNG
+	}
+	return f, nil
+}
+
+// decodeFrames decodes the frames of the animation
+func (f *apngFile) decodeFrames() error {
+	for _, frame := range f.frames {
+		var buf bytes.Buffer
+		buf.WriteString(pngSignature)
+		ihdr := append([]byte{}, f.chunks[0].data...)
+		binary.BigEndian.PutUint32(ihdr, uint32(frame.width))
+		binary.BigEndian.PutUint32(ihdr[4:], uint32(frame.height))
+		writePNGChunk(&buf, "IHDR", ihdr)
+		for _, chunk := range f.chunks {
+			if chunk.typ == "PLTE" || chunk.typ == "tRNS" {
+				writePNGChunk(&buf, chunk.typ, chunk.data)
+			}
+		}
+		writePNGChunk(&buf, "IDAT", frame.data)
+		writePNGChunk(&buf, "IEND", nil)
+
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return err
+		}
+		frame.img = img
+		if f.colorType != 3 {
+			frame.plane = newPlane(img)
+		}
+	}
+	return nil
+}
+
+// options checks the options can be used with the frames of the animation, and returns them adapted to the frames
+// with the maximum embedding depth
+func (f *apngFile) options(opts Options) (Options, int, error) {
+	if f.colorType == 3 {
+		return opts, 1, opts.validatePalette()
+	}
+	if opts.MatrixEmbedding || opts.Cost != nil || opts.masked() {
+		return opts, 0, ErrUnsupportedAnimationOption
+	}
+	if f.colorType != 6 && opts.channels()&Alpha != 0 {
+		return opts, 0, ErrInvalidChannels // the alpha channel is not written back
+	}
+	p := f.frames[0].plane
+	opts, err := p.options(opts)
+	return opts, p.maxDepth, err
+}
+
+// carrier returns the carrier embedding in the pixels of all frames of the animation with these options, at the given depth
+func (f *apngFile) carrier(opts Options, depth int) carrier {
+	frames := make([]carrier, len(f.frames))
+	for i, frame := range f.frames {
+		key := frameKey(opts.Key, i)
+		if paletted, ok := frame.img.(*image.Paletted); ok {
+			frames[i] = newPaletteCarrier(paletted, key)
+			continue
+		}
+		frameOpts := opts
+		frameOpts.Key = key
+		frames[i] = frameOpts.sampleCarrier(frame.plane.rgbImage, depth)
+	}
+	return opts.protect(framesCarrier{frames})
+}
+
+// zlibLevel returns the zlib compression level matching Options.PNGCompression
+func (opts Options) zlibLevel() int {
+	switch opts.PNGCompression {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	}
+	return zlib.DefaultCompression
+}
+
+// scanlines returns the unfiltered scanlines of the frame, in the color type and bit depth of the animation
+func (f *apngFile) scanlines(frame *apngFrame) []byte {
+	var out []byte
+	if paletted, ok := frame.img.(*image.Paletted); ok {
+		depth := int(f.depth)
+		for y := 0; y < frame.height; y++ {
+			out = append(out, 0) // filter type None
+			row := make([]byte, (frame.width*depth+7)/8)
+			for x := 0; x < frame.width; x++ {
+				bit := x * depth
+				row[bit/8] |= paletted.Pix[y*paletted.Stride+x] << uint(8-depth-bit%8)
+			}
+			out = append(out, row...)
+		}
+		return out
+	}
+
+	var pix []byte
+	var stride, size int // bytes per pixel in Pix
+	switch img := frame.plane.image().(type) {
+	case *image.Gray:
+		pix, stride, size = img.Pix, img.Stride, 1
+	case *image.Gray16:
+		pix, stride, size = img.Pix, img.Stride, 2
+	case *image.NRGBA:
+		pix, stride, size = img.Pix, img.Stride, 4
+	case *image.NRGBA64:
+		pix, stride, size = img.Pix, img.Stride, 8
+	}
+	kept := size // bytes of each pixel written
+	if f.colorType == 2 {
+		kept = size / 4 * 3 // the alpha channel of opaque frames is dropped
+	}
+	for y := 0; y < frame.height; y++ {
+		out = append(out, 0)
+		row := pix[y*stride : y*stride+frame.width*size]
+		for x := 0; x < len(row); x += size {
+			out = append(out, row[x:x+kept]...)
+		}
+	}
+	return out
+}
+
+// compress returns the zlib stream of the scanlines of the frame
+func (f *apngFile) compress(frame *apngFrame, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, opts.zlibLevel())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(f.scanlines(frame)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// write writes the animation with the frames re-encoded, each in a single IDAT or fdAT chunk
+// Other chunks are copied, and the sequence numbers of the fcTL and fdAT chunks are renumbered.
+func (f *apngFile) write(w io.Writer, opts Options) error {
+	if _, err := io.WriteString(w, pngSignature); err != nil {
+		return err
+	}
+	var sequence uint32
+	for i, chunk := range f.chunks {
+		data := chunk.data
+		if chunk.typ == "fcTL" {
+			data = append([]byte{}, data...)
+			binary.BigEndian.PutUint32(data, sequence)
+			sequence++
+		} else if f.owner[i] >= 0 {
+			frame := f.frames[f.owner[i]]
+			if i != frame.first {
+				continue
+			}
+			compressed, err := f.compress(frame, opts)
+			if err != nil {
+				return err
+			}
+			data = compressed
+			if chunk.typ == "fdAT" {
+				data = make([]byte, 4+len(compressed))
+				binary.BigEndian.PutUint32(data, sequence)
+				copy(data[4:], compressed)
+				sequence++
+			}
+		}
+		if err := writePNGChunk(w, chunk.typ, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeAPNG encodes the message across the frames of the animated PNG file read from r, and writes the result to w
+// The frames keep their pixel format, offsets, delays, disposal and blending. Paletted files are embedded like EmbedPaletted,
+// with the same options, and truecolor and grayscale files like Embed, without matrix, cost based or masked embedding.
+func (e *Encoder) EncodeAPNG(w io.Writer, r io.Reader, message []byte) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f, err := readAPNG(data)
+	if err != nil {
+		return err
+	}
+	if err := f.decodeFrames(); err != nil {
+		return err
+	}
+	opts, _, err := f.options(e.opts)
+	if err != nil {
+		return err
+	}
+
+	h, message, err := opts.container(message)
+	if err != nil {
+		return err
+	}
+	c := f.carrier(opts, h.embeddingDepth())
+	if messageCapacity(c.capacity()) < uint32(h.size()-headerSize+len(message)) {
+		return ErrMessageTooLarge
+	}
+	c.write(append(h.marshal(), message...))
+	return f.write(w, opts)
+}
+
+// DecodeAPNG returns the message embedded by EncodeAPNG in the frames of the animated PNG file read from r,
+// reassembled in frame order
+func (d *Decoder) DecodeAPNG(r io.Reader) (message []byte, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := readAPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.decodeFrames(); err != nil {
+		return nil, err
+	}
+	opts, maxDepth, err := f.options(d.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c, h, err := opts.locateWith(maxDepth, func(depth int) carrier {
+		return f.carrier(opts, depth)
+	})
+	if err != nil {
+		return nil, err
+	}
+	message, _, err = readMessage(c, h, opts.passphrase())
+	return message, err
+}
@@ -0,0 +1,411 @@
+package steganography
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"log"
+	"testing"
+)
+
+// newTestGIF creates a three frame animation, the later frames covering part of the first one with a transparent color
+func newTestGIF() *gif.GIF {
+	palette := make(color.Palette, 64)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i * 4), G: uint8(255 - i*3), B: uint8(i * i), A: 0xff}
+	}
+	overlay := append(color.Palette{color.RGBA{}}, palette[:31]...)
+
+	first := newPalettedImage(40, 30, palette)
+	second := image.NewPaletted(image.Rect(5, 4, 35, 24), overlay)
+	third := image.NewPaletted(image.Rect(10, 10, 30, 30), overlay)
+	for _, frame := range []*image.Paletted{second, third} {
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(i*7) % uint8(len(overlay))
+		}
+	}
+	return &gif.GIF{
+		Image:     []*image.Paletted{first, second, third},
+		Delay:     []int{10, 25, 40},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious},
+		LoopCount: 3,
+	}
+}
+
+func TestFramesCarrier(t *testing.T) {
+	frames := []*byteCarrier{{make([]byte, 3)}, {make([]byte, 1)}, {make([]byte, 2)}}
+	c := framesCarrier{[]carrier{frames[0], frames[1], frames[2]}}
+	if layout := c.layout(6); fmt.Sprint(layout) != "[0 1 2 0 2 0]" {
+		log.Printf("layout %v, expected [0 1 2 0 2 0]", layout)
+		t.FailNow()
+	}
+	if c.capacity() != 6 {
+		log.Printf("capacity %d, expected 6", c.capacity())
+		t.FailNow()
+	}
+
+	c.write([]byte("abcdef"))
+	if string(frames[0].data) != "adf" || string(frames[1].data) != "b" || string(frames[2].data) != "ce" {
+		log.Printf("frames hold %q %q %q", frames[0].data, frames[1].data, frames[2].data)
+		t.FailNow()
+	}
+	if read := c.read(2, 3); string(read) != "cde" {
+		log.Printf("read %q, expected \"cde\"", read)
+		t.FailNow()
+	}
+}
+
+func TestEmbedAnimatedGIF(t *testing.T) {
+	message := bytes.Repeat([]byte("animated "), 20)
+	for _, opts := range []Options{
+		{},
+		{Key: []byte("key"), Compress: true},
+		{ErrorCorrection: 4, Passphrase: []byte("passphrase")},
+	} {
+		cover := newTestGIF()
+		var buf bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).EncodeAnimatedGIF(&buf, cover, message); err != nil {
+			log.Printf("Error encoding with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		stego, err := gif.DecodeAll(&buf)
+		if err != nil {
+			log.Printf("Error decoding GIF %v", err)
+			t.FailNow()
+		}
+		if len(stego.Image) != len(cover.Image) || stego.LoopCount != cover.LoopCount {
+			log.Printf("%d frames looping %d times", len(stego.Image), stego.LoopCount)
+			t.FailNow()
+		}
+		for i, frame := range stego.Image {
+			if stego.Delay[i] != cover.Delay[i] || stego.Disposal[i] != cover.Disposal[i] || frame.Rect != cover.Image[i].Rect {
+				log.Printf("frame %d has delay %d, disposal %d and bounds %v", i, stego.Delay[i], stego.Disposal[i], frame.Rect)
+				t.FailNow()
+			}
+			if bytes.Equal(frame.Pix, cover.Image[i].Pix) {
+				log.Printf("frame %d carries nothing", i)
+				t.FailNow()
+			}
+			for j, index := range cover.Image[i].Pix {
+				if i > 0 && (index == 0) != (frame.Pix[j] == 0) {
+					log.Printf("transparency of pixel %d of frame %d changed", j, i)
+					t.FailNow()
+				}
+			}
+		}
+		if decoded, err := NewDecoder(WithOptions(opts)).DecodeAnimatedGIF(stego); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+	}
+
+	cover := newTestGIF()
+	size := NewEncoder().MaxEncodeSizeAnimatedGIF(cover)
+	if _, err := NewEncoder().EmbedAnimatedGIF(cover, make([]byte, size)); err != nil {
+		log.Printf("Error embedding %d bytes: %v", size, err)
+		t.FailNow()
+	}
+	if _, err := NewEncoder().EmbedAnimatedGIF(cover, make([]byte, size+1)); err != ErrMessageTooLarge {
+		log.Printf("expected ErrMessageTooLarge, got %v", err)
+		t.FailNow()
+	}
+	if _, err := NewEncoder(WithOptions(Options{Depth: 2})).EmbedAnimatedGIF(cover, message); err != ErrUnsupportedPaletteOption {
+		log.Printf("expected ErrUnsupportedPaletteOption, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEmbedAnimatedGIFSingleFrame(t *testing.T) {
+	cover := newTestGIF()
+	cover.Image, cover.Delay, cover.Disposal = cover.Image[:1], cover.Delay[:1], cover.Disposal[:1]
+	opts := Options{Key: []byte("key")}
+	stego, err := NewEncoder(WithOptions(opts)).EmbedAnimatedGIF(cover, []byte("still"))
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder(WithOptions(opts)).DecodePaletted(stego.Image[0]); err != nil || string(decoded) != "still" {
+		log.Printf("decoded %q (%v), expected \"still\"", decoded, err)
+		t.FailNow()
+	}
+}
+
+// newTestAPNG assembles an animated PNG from the frames, which must encode to the same PNG color type and bit depth
+// The first frame is the default image unless hidden is set, in which case the default image is not part of the animation.
+func newTestAPNG(frames []image.Image, hidden bool) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	var sequence uint32
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frame); err != nil {
+			return nil, err
+		}
+		chunks, _, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			for _, chunk := range chunks {
+				if chunk.typ == "IDAT" || chunk.typ == "IEND" {
+					break
+				}
+				writePNGChunk(&out, chunk.typ, chunk.data)
+				if chunk.typ == "IHDR" {
+					actl := make([]byte, 8)
+					binary.BigEndian.PutUint32(actl, uint32(len(frames)))
+					writePNGChunk(&out, "acTL", actl)
+				}
+			}
+			if hidden {
+				for _, chunk := range chunks {
+					if chunk.typ == "IDAT" {
+						writePNGChunk(&out, "IDAT", chunk.data)
+					}
+				}
+			}
+		}
+
+		bounds := frame.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl, sequence)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(bounds.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(bounds.Min.Y))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(10*(i+1)))
+		binary.BigEndian.PutUint16(fctl[22:], 100)
+		fctl[24], fctl[25] = byte(i%3), byte(i%2)
+		writePNGChunk(&out, "fcTL", fctl)
+		sequence++
+
+		for _, chunk := range chunks {
+			if chunk.typ != "IDAT" {
+				continue
+			}
+			if i == 0 && !hidden {
+				writePNGChunk(&out, "IDAT", chunk.data)
+				continue
+			}
+			// split the data in two fdAT chunks
+			for _, part := range [][]byte{chunk.data[:len(chunk.data)/2], chunk.data[len(chunk.data)/2:]} {
+				fdat := make([]byte, 4+len(part))
+				binary.BigEndian.PutUint32(fdat, sequence)
+				copy(fdat[4:], part)
+				writePNGChunk(&out, "fdAT", fdat)
+				sequence++
+			}
+		}
+	}
+	writePNGChunk(&out, "IEND", nil)
+	return out.Bytes(), nil
+}
+
+// subImage returns a copy of part of the image, as an image of the same type starting at 0, 0
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img.(subImager).SubImage(r))
+	sub, _ := png.Decode(&buf)
+	return sub
+}
+
+func TestEncodeAPNG(t *testing.T) {
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i * 16), G: uint8(i * 9), B: 200, A: 0xff}
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	gray := image.NewGray(image.Rect(0, 0, 40, 30))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = byte(i * 7)
+		if i%4 == 3 {
+			opaque.Pix[i] = 0xff
+		}
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * 3)
+	}
+	translucent := newTestImage(40, 30)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = byte(200 + i%50)
+	}
+	gray16 := image.NewGray16(gray.Rect)
+	for i := range gray16.Pix {
+		gray16.Pix[i] = byte(i * 5)
+	}
+	opaque16 := image.NewRGBA64(image.Rect(0, 0, 40, 30))
+	for i := range opaque16.Pix {
+		opaque16.Pix[i] = byte(i * 11)
+		if i%8 >= 6 {
+			opaque16.Pix[i] = 0xff
+		}
+	}
+
+	message := bytes.Repeat([]byte("frames "), 8)
+	for _, test := range []struct {
+		cover image.Image
+		opts  Options
+	}{
+		{translucent, Options{Channels: RGBA, Depth: 2}},
+		{opaque, Options{Key: []byte("key"), LSBMatching: true}},
+		{opaque, Options{TextureThreshold: 20}},
+		{gray, Options{Depth: 3}},
+		{gray16, Options{Depth: 6, Passphrase: []byte("passphrase")}},
+		{opaque16, Options{ErrorCorrection: 4}},
+		{newTestImage16(40, 30), Options{Compress: true}},
+		{newPalettedImage(40, 30, palette), Options{Key: []byte("key")}},
+	} {
+		frames := []image.Image{test.cover, subImage(test.cover, image.Rect(5, 5, 30, 25)), subImage(test.cover, image.Rect(10, 2, 17, 29))}
+		for _, hidden := range []bool{false, true} {
+			animation, err := newTestAPNG(frames, hidden)
+			if err != nil {
+				log.Printf("Error assembling the APNG %v", err)
+				t.FailNow()
+			}
+			var buf bytes.Buffer
+			if err := NewEncoder(WithOptions(test.opts)).EncodeAPNG(&buf, bytes.NewReader(animation), message); err != nil {
+				log.Printf("Error encoding %T with %+v and hidden default image %v: %v", test.cover, test.opts, hidden, err)
+				t.FailNow()
+			}
+			stego, err := png.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil || fmt.Sprintf("%T", stego) != fmt.Sprintf("%T", test.cover) {
+				log.Printf("default image of a %T decodes to %T (%v)", test.cover, stego, err)
+				t.FailNow()
+			}
+
+			// the frame controls are kept, in a sequence renumbered with the data chunks
+			coverChunks, _, _ := readPNGChunks(animation)
+			stegoChunks, _, err := readPNGChunks(buf.Bytes())
+			if err != nil {
+				log.Printf("Error reading chunks %v", err)
+				t.FailNow()
+			}
+			var controls []pngChunk
+			for _, chunk := range coverChunks {
+				if chunk.typ == "fcTL" {
+					controls = append(controls, chunk)
+				}
+			}
+			var sequence uint32
+			for _, chunk := range stegoChunks {
+				if chunk.typ != "fcTL" && chunk.typ != "fdAT" {
+					continue
+				}
+				if n := binary.BigEndian.Uint32(chunk.data); n != sequence {
+					log.Printf("%s chunk has sequence number %d, expected %d", chunk.typ, n, sequence)
+					t.FailNow()
+				}
+				sequence++
+				if chunk.typ == "fcTL" {
+					if len(controls) == 0 || !bytes.Equal(chunk.data[4:], controls[0].data[4:]) {
+						log.Printf("frame control %d changed", sequence-1)
+						t.FailNow()
+					}
+					controls = controls[1:]
+				}
+			}
+			if len(controls) != 0 {
+				log.Printf("%d frames lost", len(controls))
+				t.FailNow()
+			}
+
+			if decoded, err := NewDecoder(WithOptions(test.opts)).DecodeAPNG(bytes.NewReader(buf.Bytes())); err != nil || !bytes.Equal(decoded, message) {
+				log.Printf("Error decoding %T with %+v: %q, %v", test.cover, test.opts, decoded, err)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestEncodeAPNGErrors(t *testing.T) {
+	var still bytes.Buffer
+	if err := png.Encode(&still, newTestImage(20, 20)); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	if err := NewEncoder().EncodeAPNG(new(bytes.Buffer), bytes.NewReader(still.Bytes()), []byte("m")); err != ErrInvalidAPNG {
+		log.Printf("expected ErrInvalidAPNG, got %v", err)
+		t.FailNow()
+	}
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	cover, err := newTestAPNG([]image.Image{opaque, opaque}, false)
+	if err != nil {
+		log.Printf("Error assembling the APNG %v", err)
+		t.FailNow()
+	}
+	for _, test := range []struct {
+		opts Options
+		err  error
+	}{
+		{Options{MatrixEmbedding: true}, ErrUnsupportedAnimationOption},
+		{Options{Include: []image.Rectangle{image.Rect(0, 0, 5, 5)}}, ErrUnsupportedAnimationOption},
+		{Options{Channels: RGBA}, ErrInvalidChannels},
+		{Options{Depth: MaxDepth + 1}, ErrInvalidDepth},
+	} {
+		if err := NewEncoder(WithOptions(test.opts)).EncodeAPNG(new(bytes.Buffer), bytes.NewReader(cover), []byte("m")); err != test.err {
+			log.Printf("expected %v with %+v, got %v", test.err, test.opts, err)
+			t.FailNow()
+		}
+	}
+	if err := NewEncoder().EncodeAPNG(new(bytes.Buffer), bytes.NewReader(cover), make([]byte, 400)); err != ErrMessageTooLarge {
+		log.Printf("expected ErrMessageTooLarge, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncodeFileAnimated(t *testing.T) {
+	message := []byte("every frame")
+	var animation bytes.Buffer
+	if err := gif.EncodeAll(&animation, newTestGIF()); err != nil {
+		log.Printf("Error encoding GIF %v", err)
+		t.FailNow()
+	}
+	var out bytes.Buffer
+	if err := NewEncoder().EncodeFile(&out, bytes.NewReader(animation.Bytes()), message); err != nil {
+		log.Printf("Error encoding an animated GIF %v", err)
+		t.FailNow()
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		log.Printf("Error decoding GIF %v", err)
+		t.FailNow()
+	}
+	if len(g.Image) != 3 {
+		log.Printf("animated GIF written with %d frames, expected 3", len(g.Image))
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder().DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v) from GIF, expected %q", decoded, err, message)
+		t.FailNow()
+	}
+
+	cover, err := newTestAPNG([]image.Image{newTestImage(30, 30), subImage(newTestImage(30, 30), image.Rect(0, 0, 10, 10))}, false)
+	if err != nil {
+		log.Printf("Error assembling the APNG %v", err)
+		t.FailNow()
+	}
+	out.Reset()
+	if err := NewEncoder().EncodeFile(&out, bytes.NewReader(cover), message); err != nil {
+		log.Printf("Error encoding an APNG %v", err)
+		t.FailNow()
+	}
+	if !animatedPNG(out.Bytes()) {
+		log.Print("animation lost")
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder().DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v) from APNG, expected %q", decoded, err, message)
+		t.FailNow()
+	}
+}
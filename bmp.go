@@ -0,0 +1,64 @@
+package steganography
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"golang.org/x/image/bmp"
+)
+
+// BMP files are read and written with golang.org/x/image/bmp, which registers its decoder with the image package.
+// It writes grayscale images with a palette of gray levels, read back as *image.Gray by bmpGray, paletted images
+// with a palette of 256 colors, and other images with 24 bits per pixel, as its decoder ignores the alpha channel.
+
+// bmpGray returns paletted images whose palette holds the 256 gray levels in order as *image.Gray, as grayscale
+// images are written with such a palette, and other images unchanged
+func bmpGray(img image.Image) image.Image {
+	paletted, ok := img.(*image.Paletted)
+	if !ok || len(paletted.Palette) != 256 {
+		return img
+	}
+	for i, c := range paletted.Palette {
+		if color.RGBAModel.Convert(c) != (color.RGBA{uint8(i), uint8(i), uint8(i), 0xff}) {
+			return img
+		}
+	}
+	return &image.Gray{Pix: paletted.Pix, Stride: paletted.Stride, Rect: paletted.Rect}
+}
+
+// writeBMP writes the image as an uncompressed BMP file
+// Translucent and 16-bit images are refused with ErrLossyFormat, as BMP files would lose their alpha channel or the
+// low order byte of their samples, and palettes of less than 256 colors with ErrUnsupportedFormat (see fullPalette).
+func writeBMP(w io.Writer, img image.Image, opts Options) error {
+	switch src := img.(type) {
+	case *image.Gray:
+	case *image.Paletted:
+		if !opaquePalette(src.Palette) {
+			return ErrLossyFormat
+		}
+		if len(src.Palette) != 256 {
+			return ErrUnsupportedFormat
+		}
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return ErrLossyFormat
+	default:
+		rgbImage := imageToNRGBA(img)
+		if !rgbImage.Opaque() {
+			return ErrLossyFormat
+		}
+		img = rgbImage
+	}
+	return bmp.Encode(w, img)
+}
+
+// EncodeBMP encodes the message into the cover image, and writes the result as an uncompressed BMP file to w
+// Grayscale images are written with a palette of gray levels, which DecodeFile reads back as *image.Gray.
+// Translucent and 16-bit images are refused with ErrLossyFormat.
+func (e *Encoder) EncodeBMP(w io.Writer, cover image.Image, message []byte) error {
+	stego, err := e.Embed(cover, message)
+	if err != nil {
+		return err
+	}
+	return writeBMP(w, stego, e.opts)
+}
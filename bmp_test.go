@@ -0,0 +1,108 @@
+package steganography
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"log"
+	"testing"
+)
+
+func TestBMPRoundTrip(t *testing.T) {
+	rgb := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	copy(rgb.Pix, texturedImage(33, 17).Pix) // opaque
+	gray := image.NewGray(image.Rect(5, 5, 38, 22))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * 13)
+	}
+
+	for _, img := range []image.Image{rgb, gray, newPalettedImage(33, 17, palette.Plan9)} {
+		var buf bytes.Buffer
+		if err := writeBMP(&buf, img, Options{}); err != nil {
+			log.Printf("Error writing %T: %v", img, err)
+			t.FailNow()
+		}
+		decoded, format, err := image.Decode(&buf)
+		if err != nil || format != "bmp" {
+			log.Printf("%T decoded as %q (%v), expected bmp", img, format, err)
+			t.FailNow()
+		}
+		decoded = bmpGray(decoded)
+		if fmt.Sprintf("%T", decoded) != fmt.Sprintf("%T", img) || !samePixels(img, decoded) {
+			log.Printf("%T decoded to another %T", img, decoded)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncodeBMP(t *testing.T) {
+	message := []byte("bitmap")
+	var buf bytes.Buffer
+	if err := NewEncoder(WithKey([]byte("key"))).EncodeBMP(&buf, texturedImage(40, 30), message); err != nil {
+		log.Printf("Error encoding BMP %v", err)
+		t.FailNow()
+	}
+	cover := append([]byte{}, buf.Bytes()...)
+	if decoded, err := NewDecoder(WithKey([]byte("key"))).DecodeReader(bytes.NewReader(cover)); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+
+	// BMP covers are written back as BMP by EncodeFile
+	var out bytes.Buffer
+	if err := NewEncoder(WithDepth(2)).EncodeFile(&out, bytes.NewReader(cover), message[:3]); err != nil {
+		log.Printf("Error encoding file %v", err)
+		t.FailNow()
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(out.Bytes())); err != nil || format != "bmp" {
+		log.Printf("written as %q (%v), expected bmp", format, err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder().DecodeFile(&out); err != nil || !bytes.Equal(decoded, message[:3]) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message[:3])
+		t.FailNow()
+	}
+}
+
+func TestEncodeFileBMPPalette(t *testing.T) {
+	// the palette of 216 colors is padded to the 256 colors BMP files hold before embedding
+	var cover bytes.Buffer
+	if err := gif.Encode(&cover, newPalettedImage(40, 30, palette.WebSafe), nil); err != nil {
+		log.Printf("Error encoding GIF %v", err)
+		t.FailNow()
+	}
+	message := []byte("padded palette")
+	for _, format := range []string{"bmp", "tiff"} {
+		var out bytes.Buffer
+		if err := NewEncoder(WithFormat(format)).EncodeFile(&out, bytes.NewReader(cover.Bytes()), message); err != nil {
+			log.Printf("Error encoding %s file %v", format, err)
+			t.FailNow()
+		}
+		stego, _, err := image.Decode(bytes.NewReader(out.Bytes()))
+		if _, ok := stego.(*image.Paletted); err != nil || !ok {
+			log.Printf("decoded a %T (%v), expected a paletted image", stego, err)
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder().DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("decoded %q (%v) from %s, expected %q", decoded, err, format, message)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBMPUnsupported(t *testing.T) {
+	translucent := texturedImage(8, 8)
+	translucent.Pix[3] = 0x80
+	for _, img := range []image.Image{newTestImage16(4, 4), translucent} {
+		if err := writeBMP(new(bytes.Buffer), img, Options{}); err != ErrLossyFormat {
+			log.Printf("expected ErrLossyFormat writing a %T, got %v", img, err)
+			t.FailNow()
+		}
+	}
+	if err := writeBMP(new(bytes.Buffer), newPalettedImage(8, 8, palette.WebSafe), Options{}); err != ErrUnsupportedFormat {
+		log.Printf("expected ErrUnsupportedFormat writing a palette of 216 colors, got %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,247 @@
+package steganography
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"image"
+	"math/rand"
+)
+
+// carrier embeds a byte stream in the least significant bits of an image, and reads it back
+type carrier interface {
+	// capacity returns the number of bytes the image can hold, header included
+	capacity() int
+	// write embeds the stream, which must fit in the carrier capacity
+	write(data []byte)
+	// read returns length bytes of the embedded stream, starting at offset
+	read(offset, length uint32) []byte
+}
+
+// codedCarrier is a carrier storing one bit per sample, which can also embed the payload with codes operating on the samples
+type codedCarrier interface {
+	carrier
+	// writeMatrix embeds the head one bit per sample, followed by the payload coded with the (1, 2^k-1, k) Hamming code
+	writeMatrix(head, payload []byte, k int)
+	// readMatrix returns length bytes of a payload written by writeMatrix, after a head of offset bytes
+	readMatrix(offset, length uint32, k int) []byte
+	// writeSTC embeds the head one bit per sample, followed by the payload coded with the syndrome-trellis code
+	writeSTC(head, payload []byte, code stc, cost CostFunction) error
+	// readSTC returns length bytes of a payload written by writeSTC, after a head of offset bytes
+	readSTC(offset, length uint32, code stc) []byte
+}
+
+// sequentialCarrier walks pixels column by column, using the least significant bit of the red, green and blue channels in order
+type sequentialCarrier struct {
+	rgbImage *image.NRGBA
+}
+
+func (c sequentialCarrier) capacity() int {
+	return c.rgbImage.Bounds().Dx() * c.rgbImage.Bounds().Dy() * 3 / 8
+}
+
+func (c sequentialCarrier) write(data []byte) {
+	embedNRGBA(c.rgbImage, data)
+}
+
+func (c sequentialCarrier) read(offset, length uint32) []byte {
+	return decodeNRGBA(offset, length, c.rgbImage)
+}
+
+// slotCarrier stores depth bits in each (pixel, channel) slot of the image, for the selected channels
+// Slots are numbered column by column like the sequential order, and are visited in that order,
+// or in a pseudo-random order derived from a secret key, which can not be recovered without the key.
+type slotCarrier struct {
+	rgbImage *image.NRGBA
+	key      []byte // nil walks the slots in order
+	depth    int    // number of low order bits used in each slot
+	channels []int  // offsets of the selected channels in a pixel
+	alpha    bool   // skip transparent pixels, as their alpha channel carries bits
+	matching bool   // move samples to the nearest value carrying the bits, instead of replacing them
+	texture  int    // minimum texture of the pixels carrying bits, 0 using all pixels
+	region   []bool // pixels that may carry bits in row major order, nil using all pixels
+}
+
+// newSlotCarrier creates the carrier embedding depth bits per channel in the selected channels
+func newSlotCarrier(rgbImage *image.NRGBA, key []byte, depth int, channels Channels) slotCarrier {
+	return slotCarrier{
+		rgbImage: rgbImage,
+		key:      key,
+		depth:    depth,
+		channels: channels.offsets(),
+		alpha:    channels&Alpha != 0,
+	}
+}
+
+// order returns a new traversal of the slots of the image
+func (c slotCarrier) order() traversal {
+	if c.key != nil {
+		return newPermutation(c.key, c.slots())
+	}
+	return new(sequentialOrder)
+}
+
+// slots returns the number of (pixel, channel) slots of the image
+func (c slotCarrier) slots() int {
+	return c.rgbImage.Bounds().Dx() * c.rgbImage.Bounds().Dy() * len(c.channels)
+}
+
+// pixelOffset returns the position in the Pix slice of the given pixel
+func (c slotCarrier) pixelOffset(pixel int) int {
+	bounds := c.rgbImage.Bounds()
+	x, y := pixel/bounds.Dy(), pixel%bounds.Dy() // same column major numbering as the sequential order
+	return c.rgbImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+}
+
+// coordinates returns the pixel and channel of the sample at the given Pix position
+func (c slotCarrier) coordinates(offset int) (x, y, ch int) {
+	bounds := c.rgbImage.Bounds()
+	return bounds.Min.X + offset%c.rgbImage.Stride/4, bounds.Min.Y + offset/c.rgbImage.Stride, offset % 4
+}
+
+// usable reports whether the pixel at the given Pix position can carry bits
+// When the alpha channel is modulated, transparent pixels are skipped: their color may be discarded by encoders,
+// and embedding must not make them transparent. In adaptive mode, smooth pixels are skipped as changes would be visible.
+// Only bits above the embedding depth are checked, as they never change. Pixels outside the region of interest are skipped.
+func (c slotCarrier) usable(offset int) bool {
+	if c.alpha && c.rgbImage.Pix[offset+3]>>uint(c.depth) == 0 {
+		return false
+	}
+	if c.texture == 0 && c.region == nil {
+		return true
+	}
+	x, y, _ := c.coordinates(offset)
+	if !c.inRegion(x, y) {
+		return false
+	}
+	return c.texture == 0 || texture(c.rgbImage, x, y, c.depth) >= c.texture
+}
+
+// samples returns a function yielding the Pix positions of the usable slots, in traversal order
+// The function returns -1 once all slots have been visited.
+func (c slotCarrier) samples() func() int {
+	order := c.order()
+	slots := c.slots()
+	drawn := 0
+	return func() int {
+		for drawn < slots {
+			slot := order.next()
+			drawn++
+			offset := c.pixelOffset(slot / len(c.channels))
+			if c.usable(offset) {
+				return offset + c.channels[slot%len(c.channels)]
+			}
+		}
+		return -1
+	}
+}
+
+func (c slotCarrier) capacity() int {
+	usable := 0
+	for pixel := c.rgbImage.Bounds().Dx() * c.rgbImage.Bounds().Dy(); pixel > 0; pixel-- {
+		if c.usable(c.pixelOffset(pixel - 1)) {
+			usable++
+		}
+	}
+	return usable * len(c.channels) * c.depth / 8
+}
+
+func (c slotCarrier) write(data []byte) {
+	rng := c.matchingSource()
+	next := c.samples()
+	bits := len(data) * 8
+	for i := 0; i < bits; {
+		offset := next()
+		if offset < 0 {
+			return
+		}
+		// the first bit of the stream goes to the highest of the low order bits
+		var value byte
+		n := 0
+		for ; n < c.depth && i < bits; n++ {
+			value = value<<1 | getBitFromByte(data[i/8], i%8)
+			i++
+		}
+		c.store(offset, value, uint(n), uint(c.depth-n), rng) // a short last sample keeps its lowest bits
+	}
+}
+
+// store sets the n bits above shift of the sample at the given Pix position to value
+// Bits are replaced, or the sample is moved to the closest value holding them when rng is not nil (see match).
+func (c slotCarrier) store(offset int, value byte, n, shift uint, rng *rand.Rand) {
+	sample := &c.rgbImage.Pix[offset]
+	if rng == nil {
+		mask := byte(1<<n-1) << shift
+		*sample = *sample&^mask | value<<shift
+		return
+	}
+	*sample = c.match(*sample, value, n, shift, offset%4 == 3, rng)
+}
+
+// matchingSource returns the random source used for LSB matching, or nil when bits are replaced
+func (c slotCarrier) matchingSource() *rand.Rand {
+	if !c.matching {
+		return nil
+	}
+	return newMatchingSource()
+}
+
+// match returns the value closest to sample whose n bits above shift hold value, keeping the bits below shift
+// When both neighbours are as close, which is always the case for single bits, one is picked at random,
+// so samples are moved up or down by one instead of having their least significant bit overwritten.
+// Alpha samples are kept above the transparency threshold, as the pixel would be skipped when decoding.
+func (c slotCarrier) match(sample, value byte, n, shift uint, alpha bool, rng *rand.Rand) byte {
+	q := int(sample >> shift)
+	period := 1 << n
+	target := q&^(period-1) | int(value)
+	if target == q {
+		return sample
+	}
+
+	down, up := target, target+period
+	if target > q {
+		down, up = target-period, target
+	}
+	rest := int(sample) & (1<<shift - 1)
+	valid := func(q int) bool {
+		v := q<<shift | rest
+		return v >= 0 && v <= 255 && (!alpha || v>>uint(c.depth) != 0)
+	}
+
+	pickUp := q-down > up-q || (q-down == up-q && rng.Intn(2) == 1)
+	if !valid(down) || (pickUp && valid(up)) {
+		return byte(up<<shift | rest)
+	}
+	return byte(down<<shift | rest)
+}
+
+// newMatchingSource returns the random source choosing the direction of LSB matching changes, seeded from crypto/rand
+func newMatchingSource() *rand.Rand {
+	var seed [8]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))
+}
+
+func (c slotCarrier) read(offset, length uint32) []byte {
+	next := c.samples()
+	message := make([]byte, length)
+
+	skip := int(offset) * 8
+	bits := skip + len(message)*8
+	for i := 0; i < bits; {
+		position := next()
+		if position < 0 {
+			break
+		}
+		sample := c.rgbImage.Pix[position]
+		for k := c.depth - 1; k >= 0 && i < bits; k-- {
+			if i >= skip {
+				j := i - skip
+				message[j/8] = setBitInByte(message[j/8], uint32(j%8), (sample>>uint(k))&1)
+			}
+			i++
+		}
+	}
+	return message
+}
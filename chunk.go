@@ -0,0 +1,369 @@
+package steganography
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// PNG files are made of chunks, and decoders skip the ancillary chunks they do not know. The message can be stored in
+// such a chunk instead of the pixels, leaving the image untouched and its capacity unlimited, at the cost of being
+// obvious to anyone listing the chunks (see DetectPNGChunks). The chunk holds the same stream as the pixels would:
+// the header followed by the payload, encrypted, compressed and protected by error correction as configured.
+// Text chunks hold the stream encoded in base64, compressed with zlib.
+
+// DefaultPNGChunk is the private ancillary chunk type the message is stored in when Options.PNGChunk is empty
+const DefaultPNGChunk = "stGo"
+
+// pngSignature starts all PNG files
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngTextKeyword is the keyword of the text chunks holding messages
+const pngTextKeyword = "Comment"
+
+var (
+	// ErrInvalidPNG is returned when the PNG data is malformed
+	ErrInvalidPNG = errors.New("invalid PNG data")
+	// ErrInvalidPNGChunk is returned when Options.PNGChunk is not a non-standard ancillary chunk type, zTXt or iTXt
+	ErrInvalidPNGChunk = errors.New("PNG chunk must be a non-standard ancillary chunk type, zTXt or iTXt")
+	// ErrUnsupportedChunkOption is returned when PNG chunk embedding is combined with options working on pixels
+	ErrUnsupportedChunkOption = errors.New("PNG chunk embedding only supports passphrase, compression and error correction options")
+)
+
+// standardPNGChunks holds the chunk types of the PNG specification and its registered extensions, including APNG
+var standardPNGChunks = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true, "tRNS": true, "cHRM": true, "gAMA": true, "iCCP": true,
+	"sBIT": true, "sRGB": true, "cICP": true, "mDCv": true, "cLLi": true, "tEXt": true, "zTXt": true, "iTXt": true,
+	"bKGD": true, "hIST": true, "pHYs": true, "sPLT": true, "eXIf": true, "tIME": true, "acTL": true, "fcTL": true,
+	"fdAT": true, "oFFs": true, "pCAL": true, "sCAL": true, "gIFg": true, "gIFx": true, "gIFt": true, "sTER": true,
+	"dSIG": true,
+}
+
+// pngChunk is a chunk of a PNG file
+type pngChunk struct {
+	typ    string
+	data   []byte
+	offset int // position of the chunk in the file
+}
+
+// readPNGChunks splits PNG data into its chunks, checking their CRC, and returns the number of bytes following the IEND chunk
+func readPNGChunks(data []byte) ([]pngChunk, int, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, 0, ErrInvalidPNG
+	}
+	var chunks []pngChunk
+	for pos := len(pngSignature); ; {
+		if len(data)-pos < 12 {
+			return nil, 0, ErrInvalidPNG
+		}
+		length := binary.BigEndian.Uint32(data[pos:])
+		if length > uint32(len(data)-pos-12) {
+			return nil, 0, ErrInvalidPNG
+		}
+		end := pos + 8 + int(length)
+		if crc32.ChecksumIEEE(data[pos+4:end]) != binary.BigEndian.Uint32(data[end:]) {
+			return nil, 0, ErrInvalidPNG
+		}
+		chunk := pngChunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : end], offset: pos}
+		chunks = append(chunks, chunk)
+		pos = end + 4
+		if chunk.typ == "IEND" {
+			return chunks, len(data) - pos, nil
+		}
+	}
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	b := make([]byte, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], typ)
+	copy(b[8:], data)
+	binary.BigEndian.PutUint32(b[8+len(data):], crc32.ChecksumIEEE(b[4:8+len(data)]))
+	_, err := w.Write(b)
+	return err
+}
+
+// ancillary reports whether the chunk type is a valid ancillary chunk type, which decoders skip when they do not know it
+func ancillary(typ string) bool {
+	if len(typ) != 4 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if c := typ[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return typ[0]&0x20 != 0 && typ[2]&0x20 == 0 // lowercase first letter, uppercase reserved third letter
+}
+
+// textChunk reports whether the chunk type holds text
+func textChunk(typ string) bool {
+	return typ == "tEXt" || typ == "zTXt" || typ == "iTXt"
+}
+
+// byteCarrier stores the stream as is, growing with the data written
+type byteCarrier struct {
+	data []byte
+}
+
+func (c *byteCarrier) capacity() int {
+	return len(c.data)
+}
+
+func (c *byteCarrier) write(data []byte) {
+	c.data = append(c.data[:0], data...)
+}
+
+func (c *byteCarrier) read(offset, length uint32) []byte {
+	message := make([]byte, length)
+	if int(offset) < len(c.data) {
+		copy(message, c.data[offset:])
+	}
+	return message
+}
+
+// validateChunk checks the options can be used to store messages in PNG chunks
+func (opts Options) validateChunk() error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.sampleOptions() || len(opts.Key) > 0 {
+		return ErrUnsupportedChunkOption
+	}
+	if typ := opts.pngChunk(); !(typ == "zTXt" || typ == "iTXt" || ancillary(typ) && !standardPNGChunks[typ]) {
+		return ErrInvalidPNGChunk
+	}
+	return nil
+}
+
+// pngChunk returns the type of the chunk the message is stored in
+func (opts Options) pngChunk() string {
+	if opts.PNGChunk == "" {
+		return DefaultPNGChunk
+	}
+	return opts.PNGChunk
+}
+
+// chunkData returns the data of the chunk of the given type holding the stream
+func chunkData(typ string, stream []byte) ([]byte, error) {
+	if !textChunk(typ) {
+		return stream, nil
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(base64.StdEncoding.EncodeToString(stream))); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	data := append([]byte(pngTextKeyword), 0)
+	if typ == "iTXt" {
+		data = append(data, 1, 0, 0, 0) // compressed with zlib, no language tag nor translated keyword
+	} else {
+		data = append(data, 0) // zlib
+	}
+	return append(data, compressed.Bytes()...), nil
+}
+
+// chunkStream returns the stream a chunk may hold, nil when it can not hold one
+func chunkStream(chunk pngChunk) []byte {
+	if !textChunk(chunk.typ) {
+		if ancillary(chunk.typ) && !standardPNGChunks[chunk.typ] {
+			return chunk.data
+		}
+		return nil
+	}
+
+	keyword := bytes.IndexByte(chunk.data, 0)
+	if keyword < 0 {
+		return nil
+	}
+	text := chunk.data[keyword+1:]
+	compressed := chunk.typ == "zTXt"
+	if chunk.typ == "iTXt" {
+		if len(text) < 2 {
+			return nil
+		}
+		compressed = text[0] == 1
+		text = text[2:]
+		for i := 0; i < 2; i++ { // skip the language tag and the translated keyword
+			end := bytes.IndexByte(text, 0)
+			if end < 0 {
+				return nil
+			}
+			text = text[end+1:]
+		}
+	} else if compressed {
+		if len(text) == 0 {
+			return nil
+		}
+		text = text[1:]
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(text))
+		if err != nil {
+			return nil
+		}
+		limit := base64.StdEncoding.EncodedLen(maxDecompressedSize)
+		if text, err = ioutil.ReadAll(io.LimitReader(zr, int64(limit)+1)); err != nil || len(text) > limit {
+			return nil
+		}
+	}
+	stream, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return nil
+	}
+	return stream
+}
+
+// EncodePNGChunk writes the cover image as PNG to w, with the message stored in an ancillary chunk before its end
+// The pixels are not changed. The chunk type is set by Options.PNGChunk, and only the Passphrase, Compress and
+// ErrorCorrection options are supported. Text chunks hold at most 64 MiB, past which decoders stop inflating them.
+func (e *Encoder) EncodePNGChunk(w io.Writer, cover image.Image, message []byte) error {
+	opts := e.opts
+	if err := opts.validateChunk(); err != nil {
+		return err
+	}
+	h, message, err := opts.container(message)
+	if err != nil {
+		return err
+	}
+	if uint64(h.size())+uint64(len(message)) > 1<<31-1 {
+		return ErrMessageTooLarge
+	}
+
+	c := new(byteCarrier)
+	opts.protect(c).write(append(h.marshal(), message...))
+	if textChunk(opts.pngChunk()) && len(c.data) > maxDecompressedSize {
+		return ErrMessageTooLarge // decoders stop inflating text chunks past that size
+	}
+	data, err := chunkData(opts.pngChunk(), c.data)
+	if err != nil {
+		return err
+	}
+	if len(data) > 1<<31-1 {
+		return ErrMessageTooLarge
+	}
+
+	var buf bytes.Buffer
+	if err := opts.pngEncoder().Encode(&buf, cover); err != nil {
+		return err
+	}
+	chunks, _, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	end := chunks[len(chunks)-1].offset // the IEND chunk
+	if _, err := w.Write(buf.Bytes()[:end]); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, opts.pngChunk(), data); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes()[end:])
+	return err
+}
+
+// DecodePNGChunk returns the message stored by EncodePNGChunk in the PNG file read from r
+// The chunks which may hold a message are tried in turn, whatever their type.
+func (d *Decoder) DecodePNGChunk(r io.Reader) (message []byte, err error) {
+	opts := d.opts
+	opts.PNGChunk = ""
+	if err = opts.validateChunk(); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunks, _, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		stream := chunkStream(chunk)
+		if stream == nil {
+			continue
+		}
+		c := opts.protect(&byteCarrier{stream})
+		h, err := readHeader(c)
+		if err != nil || h.embeddingDepth() != 1 || h.errorCorrection() != opts.ErrorCorrection {
+			continue
+		}
+		message, _, err = readMessage(c, h, opts.passphrase())
+		return message, err
+	}
+	return nil, ErrNoPayload
+}
+
+// PNGChunk describes a chunk of a PNG file reported by DetectPNGChunks
+type PNGChunk struct {
+	Type   string // chunk type, empty for data following the end of the file
+	Offset int    // position of the chunk in the file
+	Length int    // length of the chunk data
+	Reason string // why the chunk is suspicious
+}
+
+// DetectPNGChunks lists the chunks of the PNG file read from r which may hide data: chunks carrying the header of
+// this package, chunks of non-standard types, text chunks holding binary or encoded data, and data after the end of the file
+func DetectPNGChunks(r io.Reader) ([]PNGChunk, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunks, trailing, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var suspicious []PNGChunk
+	for _, chunk := range chunks {
+		var reason string
+		stream := chunkStream(chunk)
+		_, err := parseHeader(stream)
+		switch {
+		case err == nil:
+			reason = "holds a steganography header"
+		case standardPNGChunks[chunk.typ] && !textChunk(chunk.typ):
+			continue
+		case !standardPNGChunks[chunk.typ] && chunk.typ[1]&0x20 != 0:
+			reason = "private chunk type"
+		case !standardPNGChunks[chunk.typ]:
+			reason = "unknown chunk type"
+		case len(stream) >= headerSize: // shorter texts may be words which happen to be valid base64
+			reason = "text chunk holding base64 encoded data"
+		case binaryText(chunk):
+			reason = "text chunk holding binary data"
+		default:
+			continue
+		}
+		suspicious = append(suspicious, PNGChunk{Type: chunk.typ, Offset: chunk.offset, Length: len(chunk.data), Reason: reason})
+	}
+	if trailing > 0 {
+		suspicious = append(suspicious, PNGChunk{Offset: len(data) - trailing, Length: trailing, Reason: "data after the IEND chunk"})
+	}
+	return suspicious, nil
+}
+
+// binaryText reports whether an uncompressed tEXt chunk holds control characters, which Latin-1 text does not use
+func binaryText(chunk pngChunk) bool {
+	if chunk.typ != "tEXt" {
+		return false
+	}
+	for _, c := range chunk.data {
+		if c < 0x20 && c != 0 && c != '\n' || c >= 0x7f && c < 0xa1 {
+			return true
+		}
+	}
+	return false
+}
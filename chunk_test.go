@@ -0,0 +1,175 @@
+package steganography
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"image/png"
+	"log"
+	"testing"
+)
+
+// insertPNGChunk returns the PNG file with a chunk inserted before its IEND chunk
+func insertPNGChunk(data []byte, typ string, chunk []byte) ([]byte, error) {
+	chunks, _, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	end := chunks[len(chunks)-1].offset
+	var buf bytes.Buffer
+	buf.Write(data[:end])
+	if err := writePNGChunk(&buf, typ, chunk); err != nil {
+		return nil, err
+	}
+	buf.Write(data[end:])
+	return buf.Bytes(), nil
+}
+
+func TestEncodePNGChunk(t *testing.T) {
+	cover := texturedImage(20, 10)
+	var plain bytes.Buffer
+	if err := png.Encode(&plain, cover); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	pixels, err := png.Decode(&plain)
+	if err != nil {
+		log.Printf("Error decoding PNG %v", err)
+		t.FailNow()
+	}
+	message := bytes.Repeat([]byte("far more than the pixels could hold "), 100)
+
+	for _, opts := range []Options{
+		{},
+		{PNGChunk: "prIv"},
+		{PNGChunk: "zTXt", Compress: true},
+		{PNGChunk: "iTXt", Passphrase: []byte("passphrase")},
+		{ErrorCorrection: 8},
+	} {
+		var buf bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).EncodePNGChunk(&buf, cover, message); err != nil {
+			log.Printf("Error encoding with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		stego, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			log.Printf("PNG with the chunk can not be decoded: %v", err)
+			t.FailNow()
+		}
+		if !samePixels(pixels, stego) {
+			log.Printf("pixels changed with %+v", opts)
+			t.FailNow()
+		}
+		decoder := NewDecoder(WithOptions(Options{Passphrase: opts.Passphrase, ErrorCorrection: opts.ErrorCorrection}))
+		if decoded, err := decoder.DecodePNGChunk(bytes.NewReader(buf.Bytes())); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestDetectPNGChunks(t *testing.T) {
+	var plain bytes.Buffer
+	if err := png.Encode(&plain, newTestImage(8, 8)); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	data, err := insertPNGChunk(plain.Bytes(), "tEXt", []byte("Software\x00Go"))
+	if err != nil {
+		log.Printf("Error inserting a chunk %v", err)
+		t.FailNow()
+	}
+	if suspicious, err := DetectPNGChunks(bytes.NewReader(data)); err != nil || len(suspicious) != 0 {
+		log.Printf("plain PNG reported %v (%v)", suspicious, err)
+		t.FailNow()
+	}
+
+	var stego bytes.Buffer
+	if err := NewEncoder(WithOptions(Options{PNGChunk: "zTXt"})).EncodePNGChunk(&stego, newTestImage(8, 8), []byte("message")); err != nil {
+		log.Printf("Error encoding message %v", err)
+		t.FailNow()
+	}
+	data, err = insertPNGChunk(stego.Bytes(), "vpAg", []byte{0, 1, 2, 3})
+	if err == nil {
+		data, err = insertPNGChunk(data, "tEXt", []byte("Comment\x00\x01\x02\x03"))
+	}
+	if err != nil {
+		log.Printf("Error inserting a chunk %v", err)
+		t.FailNow()
+	}
+	data = append(data, "trailing"...)
+
+	suspicious, err := DetectPNGChunks(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error detecting chunks %v", err)
+		t.FailNow()
+	}
+	expected := []PNGChunk{
+		{Type: "zTXt", Reason: "holds a steganography header"},
+		{Type: "vpAg", Reason: "private chunk type"},
+		{Type: "tEXt", Length: 11, Reason: "text chunk holding binary data"},
+		{Offset: len(data) - 8, Length: 8, Reason: "data after the IEND chunk"},
+	}
+	if len(suspicious) != len(expected) {
+		log.Printf("reported %v, expected %v", suspicious, expected)
+		t.FailNow()
+	}
+	for i, chunk := range suspicious {
+		if chunk.Type != expected[i].Type || chunk.Reason != expected[i].Reason ||
+			expected[i].Length > 0 && (chunk.Length != expected[i].Length || chunk.Type == "" && chunk.Offset != expected[i].Offset) {
+			log.Printf("reported %v, expected %v", chunk, expected[i])
+			t.FailNow()
+		}
+	}
+}
+
+func TestPNGChunkErrors(t *testing.T) {
+	cover := newTestImage(8, 8)
+	var buf bytes.Buffer
+	if err := NewEncoder(WithOptions(Options{Key: []byte("key")})).EncodePNGChunk(&buf, cover, []byte("m")); err != ErrUnsupportedChunkOption {
+		log.Printf("expected ErrUnsupportedChunkOption, got %v", err)
+		t.FailNow()
+	}
+	for _, typ := range []string{"tEXt", "IDAT", "gAMA", "st0o", "stgo", "toolong"} {
+		if err := NewEncoder(WithOptions(Options{PNGChunk: typ})).EncodePNGChunk(&buf, cover, []byte("m")); err != ErrInvalidPNGChunk {
+			log.Printf("expected ErrInvalidPNGChunk for %q, got %v", typ, err)
+			t.FailNow()
+		}
+	}
+
+	buf.Reset()
+	if err := png.Encode(&buf, cover); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	if _, err := NewDecoder().DecodePNGChunk(bytes.NewReader(buf.Bytes())); err != ErrNoPayload {
+		log.Printf("expected ErrNoPayload, got %v", err)
+		t.FailNow()
+	}
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[len(pngSignature)+10]++ // in the IHDR chunk
+	if _, err := NewDecoder().DecodePNGChunk(bytes.NewReader(corrupted)); err != ErrInvalidPNG {
+		log.Printf("expected ErrInvalidPNG, got %v", err)
+		t.FailNow()
+	}
+	if _, err := DetectPNGChunks(bytes.NewReader([]byte("not a PNG"))); err != ErrInvalidPNG {
+		log.Printf("expected ErrInvalidPNG, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestChunkStreamBounded(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	block := bytes.Repeat([]byte("A"), 1<<20)
+	for n := 0; n <= base64.StdEncoding.EncodedLen(maxDecompressedSize); n += len(block) {
+		zw.Write(block)
+	}
+	zw.Close()
+
+	chunk := pngChunk{typ: "zTXt", data: append([]byte("Comment\x00\x00"), compressed.Bytes()...)}
+	if stream := chunkStream(chunk); stream != nil {
+		log.Printf("text chunk inflated to %d bytes past the bound", len(stream))
+		t.FailNow()
+	}
+}
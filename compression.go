@@ -0,0 +1,77 @@
+package steganography
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// compressionDeflate identifies payloads compressed with DEFLATE (RFC 1951), recorded in the header extension
+const compressionDeflate byte = 1
+
+// compressionParamsSize is the size of the header extension written for compressed payloads
+const compressionParamsSize = 1 + 4
+
+// maxDecompressedSize bounds the length of compressed messages, far above what images can hold uncompressed, so that
+// crafted headers can not make decoders inflate a small payload into gigabytes. Larger messages are stored uncompressed.
+const maxDecompressedSize = 64 << 20
+
+// ErrDecompression is returned when a compressed payload can not be decompressed
+var ErrDecompression = errors.New("payload decompression failed")
+
+// compress returns the DEFLATE compressed message, and whether it is smaller than the message once the header extension is counted
+func compress(message []byte) ([]byte, bool) {
+	if len(message) > maxDecompressedSize {
+		return nil, false
+	}
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return nil, false
+	}
+	if _, err = w.Write(message); err != nil {
+		return nil, false
+	}
+	if err = w.Close(); err != nil {
+		return nil, false
+	}
+	if b.Len()+compressionParamsSize >= len(message) {
+		return nil, false // compression does not help, the message is stored as is
+	}
+	return b.Bytes(), true
+}
+
+// decompress returns the message compressed with the algorithm recorded in the header
+// Decompression stops past the length recorded in the header, which the message must match.
+func decompress(h header, payload []byte) ([]byte, error) {
+	if h.compression != compressionDeflate {
+		return nil, ErrInvalidHeader
+	}
+	r := io.LimitReader(flate.NewReader(bytes.NewReader(payload)), int64(h.uncompressed)+1)
+	message, err := ioutil.ReadAll(r)
+	if err != nil || len(message) != int(h.uncompressed) {
+		return nil, ErrDecompression
+	}
+	return message, nil
+}
+
+// storedSize returns the number of bytes stored in the image for the message with the options, header extensions excluded
+func (opts Options) storedSize(message []byte) int {
+	if opts.Compress {
+		if compressed, ok := compress(message); ok {
+			return len(compressed) + compressionParamsSize
+		}
+	}
+	return len(message)
+}
+
+// Fits reports whether the message can be embedded in the image by the Encoder, after compression when it is enabled
+func (e *Encoder) Fits(img image.Image, message []byte) bool {
+	if _, err := newPlane(img).options(e.opts); err != nil {
+		return false
+	}
+	return e.opts.storedSize(message) <= int(e.MaxEncodeSize(img))
+}
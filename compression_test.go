@@ -0,0 +1,156 @@
+package steganography
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeCompressed(t *testing.T) {
+	cover := newTestImage(60, 60)
+	message := []byte(strings.Repeat(`{"name": "stegosaurus", "plates": 17, "period": "Late Jurassic"}`, 40))
+	opts := Options{Compress: true}
+
+	if NewEncoder().Fits(cover, message) || uint32(len(message)) <= MaxEncodeSize(cover) {
+		log.Print("the message should not fit without compression")
+		t.FailNow()
+	}
+	if !NewEncoder(WithOptions(opts)).Fits(cover, message) {
+		log.Print("the message should fit after compression")
+		t.FailNow()
+	}
+
+	stego, err := EmbedImage(cover, message, opts)
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	h, err := readHeader(sequentialCarrier{stego.(*image.NRGBA)})
+	if err != nil || h.flags&flagCompressed == 0 || h.compression != compressionDeflate {
+		log.Printf("compression not recorded in the header: %v", err)
+		t.FailNow()
+	}
+
+	for _, decode := range []func(image.Image) ([]byte, error){DecodeAuto, DecodeMessage} {
+		msg, err := decode(stego)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, message) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+	}
+
+	opts = Options{Compress: true, Passphrase: []byte("passphrase"), Key: []byte("secret key")}
+	stego, err = EmbedImage(cover, message, opts)
+	if err != nil {
+		log.Printf("Error embedding encrypted message %v", err)
+		t.FailNow()
+	}
+	if msg, err := NewDecoder(WithOptions(opts)).Decode(stego); err != nil || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding encrypted message %v", err)
+		t.FailNow()
+	}
+}
+
+func TestCompressionSkippedWhenUseless(t *testing.T) {
+	cover := newTestImage(60, 60)
+	message := make([]byte, 200)
+	rand.Read(message)
+
+	stego, err := EmbedImage(cover, message, Options{Compress: true})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	h, err := readHeader(sequentialCarrier{stego.(*image.NRGBA)})
+	if err != nil || h.flags&flagCompressed != 0 || h.length != uint32(len(message)) {
+		log.Printf("random message stored compressed: %v", err)
+		t.FailNow()
+	}
+	if msg, err := DecodeAuto(stego); err != nil || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+
+	// an uncompressed image is identical to one embedded without compression
+	plain, _ := EmbedImage(cover, message, Options{})
+	if !bytes.Equal(plain.(*image.NRGBA).Pix, stego.(*image.NRGBA).Pix) {
+		log.Print("skipped compression changed the image")
+		t.FailNow()
+	}
+}
+
+func TestDecompressInvalidPayload(t *testing.T) {
+	h := newHeader(nil)
+	h.setCompression(compressionDeflate, 3)
+	if _, err := decompress(h, []byte{0xff, 0xff, 0xff}); err != ErrDecompression {
+		log.Printf("Uncaught invalid compressed payload: %v", err)
+		t.FailNow()
+	}
+
+	b := h.marshal()
+	b[len(b)-compressionParamsSize] = 7
+	parsed, _ := parseHeader(b)
+	if err := parsed.parseExtensions(b); err != ErrInvalidHeader {
+		log.Printf("Uncaught unknown compression algorithm: %v", err)
+		t.FailNow()
+	}
+
+	h.setCompression(compressionDeflate, maxDecompressedSize+1)
+	b = h.marshal()
+	parsed, _ = parseHeader(b)
+	if err := parsed.parseExtensions(b); err != ErrInvalidHeader {
+		log.Printf("Uncaught decompressed length above the bound: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecompressBounded(t *testing.T) {
+	zeros := make([]byte, 1<<20)
+	payload, ok := compress(zeros)
+	if !ok {
+		log.Print("zeros should compress")
+		t.FailNow()
+	}
+
+	h := newHeader(payload)
+	h.setCompression(compressionDeflate, len(zeros))
+	if message, err := decompress(h, payload); err != nil || !bytes.Equal(message, zeros) {
+		log.Printf("Error decompressing message %v", err)
+		t.FailNow()
+	}
+
+	for _, length := range []int{1024, len(zeros) + 1} {
+		h.setCompression(compressionDeflate, length)
+		if _, err := decompress(h, payload); err != ErrDecompression {
+			log.Printf("Uncaught decompressed length %d not matching the header: %v", length, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncryptedCompressedLengthSealed(t *testing.T) {
+	passphrase := []byte("passphrase")
+	message := []byte(strings.Repeat("sealed length ", 40))
+	h, payload, err := Options{Compress: true, Passphrase: passphrase}.container(message)
+	if err != nil {
+		log.Printf("Error sealing message %v", err)
+		t.FailNow()
+	}
+	if h.flags&flagCompressed == 0 || h.size() != headerSize+encryptionParamsSize+1 {
+		log.Printf("header of %d bytes records the decompressed length", h.size())
+		t.FailNow()
+	}
+
+	c := &byteCarrier{append(h.marshal(), payload...)}
+	if decoded, _, err := readMessage(c, h, passphrase); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,64 @@
+package steganography
+
+import "image"
+
+// CostFunction returns the distortion caused by changing the least significant bit of channel ch
+// (0 to 3 for red, green, blue and alpha) of the pixel at x, y of the cover image. Costs must not be negative,
+// and math.Inf(1) marks samples that must never be changed. See Options.Cost.
+type CostFunction func(img *image.NRGBA, x, y, ch int) float64
+
+// UniformCost gives the same cost to every sample, minimizing the number of changed samples
+func UniformCost(img *image.NRGBA, x, y, ch int) float64 {
+	return 1
+}
+
+// TextureCost makes changes cheap in textured or noisy regions and expensive in smooth ones, where they are easier to detect
+// The cost is the inverse of the sum of the absolute differences between the sample and its 8 neighbours in the same channel.
+func TextureCost(img *image.NRGBA, x, y, ch int) float64 {
+	bounds := img.Bounds()
+	center := int(img.Pix[img.PixOffset(x, y)+ch])
+
+	var variation int
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			p := image.Point{x + dx, y + dy}
+			if (dx == 0 && dy == 0) || !p.In(bounds) {
+				continue
+			}
+			variation += abs(int(img.Pix[img.PixOffset(p.X, p.Y)+ch]) - center)
+		}
+	}
+	return 1 / (1 + float64(variation))
+}
+
+// texture measures the local variation around the pixel at x, y, ignoring the depth low order bits of every channel
+// It is the sum of the absolute differences between the red, green and blue values of the pixel and of its 8 neighbours.
+// As embedding never changes the bits it reads, decoders compute the same texture on the stego image.
+func texture(img *image.NRGBA, x, y, depth int) int {
+	bounds := img.Bounds()
+	mask := ^byte(1<<uint(depth) - 1)
+	center := img.Pix[img.PixOffset(x, y):]
+
+	var variation int
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			p := image.Point{x + dx, y + dy}
+			if (dx == 0 && dy == 0) || !p.In(bounds) {
+				continue
+			}
+			neighbour := img.Pix[img.PixOffset(p.X, p.Y):]
+			for ch := 0; ch < 3; ch++ {
+				variation += abs(int(neighbour[ch]&mask) - int(center[ch]&mask))
+			}
+		}
+	}
+	return variation
+}
+
+// abs returns the absolute value of x
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,251 @@
+package steganography
+
+import (
+	"image"
+	"image/png"
+	"io"
+)
+
+// Option configures an Encoder or a Decoder, see NewEncoder and NewDecoder
+type Option func(*Options)
+
+// WithOptions applies all the settings of an Options value
+func WithOptions(opts Options) Option {
+	return func(o *Options) {
+		*o = opts
+	}
+}
+
+// WithKey scatters the message over the image in a pseudo-random order derived from the key, see Options.Key
+func WithKey(key []byte) Option {
+	return func(o *Options) {
+		o.Key = key
+	}
+}
+
+// WithPassphrase encrypts the message with a key derived from the passphrase, see Options.Passphrase
+func WithPassphrase(passphrase []byte) Option {
+	return func(o *Options) {
+		o.Passphrase = passphrase
+	}
+}
+
+// WithDepth embeds depth low order bits in each channel, see Options.Depth
+func WithDepth(depth int) Option {
+	return func(o *Options) {
+		o.Depth = depth
+	}
+}
+
+// WithChannels selects the channels carrying the message, see Options.Channels
+func WithChannels(channels Channels) Option {
+	return func(o *Options) {
+		o.Channels = channels
+	}
+}
+
+// WithLSBMatching increments or decrements samples instead of overwriting their low order bits, see Options.LSBMatching
+func WithLSBMatching() Option {
+	return func(o *Options) {
+		o.LSBMatching = true
+	}
+}
+
+// WithMatrixEmbedding embeds the message with a Hamming code, changing fewer samples, see Options.MatrixEmbedding
+func WithMatrixEmbedding() Option {
+	return func(o *Options) {
+		o.MatrixEmbedding = true
+	}
+}
+
+// WithCost embeds the message with a syndrome-trellis code minimizing the total cost of the changes, see Options.Cost
+func WithCost(cost CostFunction) Option {
+	return func(o *Options) {
+		o.Cost = cost
+	}
+}
+
+// WithTextureThreshold only embeds in pixels whose texture is at least the threshold, see Options.TextureThreshold
+func WithTextureThreshold(threshold int) Option {
+	return func(o *Options) {
+		o.TextureThreshold = threshold
+	}
+}
+
+// WithMask restricts the message to the pixels where the mask is opaque, see Options.Mask
+func WithMask(mask image.Image) Option {
+	return func(o *Options) {
+		o.Mask = mask
+	}
+}
+
+// WithInclude restricts the message to the pixels inside the rectangles, see Options.Include
+func WithInclude(rectangles ...image.Rectangle) Option {
+	return func(o *Options) {
+		o.Include = append(o.Include[:len(o.Include):len(o.Include)], rectangles...) // never share the caller array
+	}
+}
+
+// WithExclude leaves the pixels inside the rectangles untouched, see Options.Exclude
+func WithExclude(rectangles ...image.Rectangle) Option {
+	return func(o *Options) {
+		o.Exclude = append(o.Exclude[:len(o.Exclude):len(o.Exclude)], rectangles...)
+	}
+}
+
+// WithCompression compresses the message before embedding it when this makes it smaller, see Options.Compress
+func WithCompression() Option {
+	return func(o *Options) {
+		o.Compress = true
+	}
+}
+
+// WithErrorCorrection protects the message with the given number of Reed-Solomon parity bytes per codeword, see Options.ErrorCorrection
+func WithErrorCorrection(parity int) Option {
+	return func(o *Options) {
+		o.ErrorCorrection = parity
+	}
+}
+
+// WithFormat selects the image format written by Encoder.EncodeFile, see Options.Format
+func WithFormat(format string) Option {
+	return func(o *Options) {
+		o.Format = format
+	}
+}
+
+// WithPNGCompression sets the compression level of the PNG files written, see Options.PNGCompression
+func WithPNGCompression(level png.CompressionLevel) Option {
+	return func(o *Options) {
+		o.PNGCompression = level
+	}
+}
+
+// WithTIFFCompression sets the compression of the TIFF files written, see Options.TIFFCompression
+func WithTIFFCompression(compression TIFFCompression) Option {
+	return func(o *Options) {
+		o.TIFFCompression = compression
+	}
+}
+
+// WithPNGChunk sets the type of the chunk Encoder.EncodePNGChunk stores the message in, see Options.PNGChunk
+func WithPNGChunk(chunkType string) Option {
+	return func(o *Options) {
+		o.PNGChunk = chunkType
+	}
+}
+
+// Encoder embeds messages in images, as configured by the options it was created with
+// The package level Encode functions use an Encoder with the default configuration.
+type Encoder struct {
+	opts Options
+}
+
+// NewEncoder creates an Encoder configured by the options, applied in order
+/*
+	Example:
+		encoder := steganography.NewEncoder(steganography.WithChannels(steganography.RGBA), steganography.WithKey(key))
+		err := encoder.Encode(w, img, message)
+*/
+func NewEncoder(options ...Option) *Encoder {
+	e := new(Encoder)
+	for _, option := range options {
+		option(&e.opts)
+	}
+	return e
+}
+
+// Embed encodes a given message into a copy of the cover image, and returns the copy (see EmbedImage)
+func (e *Encoder) Embed(cover image.Image, message []byte) (image.Image, error) {
+	p := newPlane(cover)
+	opts, err := p.options(e.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h, message, err := opts.container(message)
+	if err != nil {
+		return nil, err
+	}
+	if err := embedPayload(p.rgbImage, opts, h, message); err != nil {
+		return nil, err
+	}
+	return p.image(), nil
+}
+
+// Encode encodes a given message into the cover image, and writes the result as PNG to w
+// 16-bit images are written as 16-bit PNG files.
+func (e *Encoder) Encode(w io.Writer, cover image.Image, message []byte) error {
+	stego, err := e.Embed(cover, message)
+	if err != nil {
+		return err
+	}
+	return e.opts.pngEncoder().Encode(w, stego)
+}
+
+// MaxEncodeSize given an image will find how many bytes can be stored in that image by the Encoder
+// When the alpha channel is selected, the transparent pixels of the image are not counted, nor are smooth pixels in adaptive mode,
+// nor pixels outside the region of interest. 16-bit images accept depths up to MaxDepth16.
+func (e *Encoder) MaxEncodeSize(img image.Image) uint32 {
+	p := newPlane(img)
+	opts, err := p.options(e.opts)
+	if err != nil {
+		return 0
+	}
+
+	var size uint32
+	if opts.selective() || opts.ErrorCorrection > 0 {
+		size = messageCapacity(opts.carrier(p.rgbImage, opts.depth()).capacity())
+	} else {
+		width := img.Bounds().Dx()
+		height := img.Bounds().Dy()
+		size = messageCapacity((width * height * len(opts.channels().offsets()) * opts.depth()) / 8)
+	}
+
+	overhead := uint32(opts.overhead())
+	if size < overhead {
+		return 0
+	}
+	return size - overhead
+}
+
+// Decoder reads messages back from images, configured with the same options as the Encoder that embedded them
+// The depth is recorded in the header, so it does not need to be configured.
+type Decoder struct {
+	opts Options
+}
+
+// NewDecoder creates a Decoder configured by the options, applied in order
+func NewDecoder(options ...Option) *Decoder {
+	d := new(Decoder)
+	for _, option := range options {
+		option(&d.opts)
+	}
+	return d
+}
+
+// Decode returns the message embedded in the image, after validating its header and checksum (see DecodeAuto)
+func (d *Decoder) Decode(img image.Image) (message []byte, err error) {
+	message, _, err = d.DecodeCorrected(img)
+	return message, err
+}
+
+// DecodeCorrected returns the message embedded in the image like Decode, and the number of corrupted bytes repaired
+// by the error correction (see Options.ErrorCorrection). Images too damaged to be repaired are reported with ErrTooManyErrors.
+func (d *Decoder) DecodeCorrected(img image.Image) (message []byte, corrected int, err error) {
+	p := newPlane(img)
+	opts, err := p.options(d.opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeCorrected(p.rgbImage, opts, opts.passphrase(), p.maxDepth)
+}
+
+// DecodeReader decodes the image read from r, and returns the message embedded in it
+func (d *Decoder) DecodeReader(r io.Reader) (message []byte, err error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.Decode(img)
+}
@@ -0,0 +1,67 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"testing"
+)
+
+func TestEncoderDecoder(t *testing.T) {
+	key := []byte("secret key")
+	encoder := NewEncoder(WithChannels(RGBA), WithKey(key), WithDepth(2))
+	decoder := NewDecoder(WithChannels(RGBA), WithKey(key))
+	cover := newTestImage(60, 60)
+
+	w := new(bytes.Buffer)
+	if err := encoder.Encode(w, cover, bitmessage); err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	msg, err := decoder.DecodeReader(w)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	opts := Options{Key: key, Depth: 2, Channels: RGBA}
+	if encoder.MaxEncodeSize(cover) != NewEncoder(WithOptions(opts)).MaxEncodeSize(cover) {
+		log.Printf("capacity mismatch: %d != %d", encoder.MaxEncodeSize(cover), NewEncoder(WithOptions(opts)).MaxEncodeSize(cover))
+		t.FailNow()
+	}
+}
+
+func TestEncoderMatchesOptions(t *testing.T) {
+	opts := Options{Key: []byte("secret key"), Channels: Red | Blue}
+	cover := newTestImage(48, 48)
+
+	stego, err := NewEncoder(WithKey(opts.Key), WithChannels(opts.Channels)).Embed(cover, bitmessage)
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	expected, err := EmbedImage(cover, bitmessage, opts)
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(stego.(*image.NRGBA).Pix, expected.(*image.NRGBA).Pix) {
+		log.Print("Encoder and EmbedImage produced different images")
+		t.FailNow()
+	}
+
+	// later options override earlier ones
+	if _, err = NewDecoder(WithOptions(opts), WithChannels(Red|Blue|Green)).Decode(stego); err != ErrInvalidHeader {
+		log.Printf("Uncaught channel mismatch: %v", err)
+		t.FailNow()
+	}
+	if _, err = NewEncoder(WithDepth(MaxDepth+1)).Embed(cover, bitmessage); err != ErrInvalidDepth {
+		log.Printf("Uncaught invalid depth: %v", err)
+		t.FailNow()
+	}
+}
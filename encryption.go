@@ -0,0 +1,157 @@
+package steganography
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"image"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// scryptLogN is the scrypt cost parameter (log2 of N) used for new messages, recorded in the header
+	scryptLogN = 15
+	// scryptMaxLogN bounds the cost accepted when decoding: scrypt allocates 128*r*N bytes before the message
+	// is authenticated, so headers may not ask for more than twice the cost of new messages (64 MiB)
+	scryptMaxLogN = scryptLogN + 1
+	// scryptR and scryptP are the scrypt block size and parallelization parameters
+	scryptR = 8
+	scryptP = 1
+
+	saltSize = 16
+	// encryptionParamsSize is the size of the header extension written for encrypted payloads
+	encryptionParamsSize = 1 + saltSize + 12
+	// EncryptionOverhead is the number of bytes added to the message by EncodeEncrypted (header extension and authentication tag)
+	EncryptionOverhead = encryptionParamsSize + 16
+)
+
+var (
+	// ErrAuthentication is returned by DecodeEncrypted when the passphrase is wrong, or the message was tampered with
+	ErrAuthentication = errors.New("message authentication failed: wrong passphrase or tampered image")
+	// ErrPassphraseRequired is returned when decoding an encrypted message without a passphrase
+	ErrPassphraseRequired = errors.New("message is encrypted, a passphrase is required")
+	// ErrNotEncrypted is returned by DecodeEncrypted when the message was not encrypted
+	ErrNotEncrypted = errors.New("message is not encrypted")
+)
+
+// encryptionParams holds the key derivation and sealing parameters stored in the header of encrypted payloads
+type encryptionParams struct {
+	logN  byte
+	salt  [saltSize]byte
+	nonce [12]byte
+}
+
+// newEncryptionParams generates fresh random parameters for a new message
+func newEncryptionParams() (p encryptionParams, err error) {
+	p.logN = scryptLogN
+	if _, err = io.ReadFull(rand.Reader, p.salt[:]); err != nil {
+		return p, err
+	}
+	_, err = io.ReadFull(rand.Reader, p.nonce[:])
+	return p, err
+}
+
+// marshal appends the binary representation of the parameters to b
+func (p encryptionParams) marshal(b []byte) []byte {
+	b = append(b, p.logN)
+	b = append(b, p.salt[:]...)
+	return append(b, p.nonce[:]...)
+}
+
+// parse decodes the parameters from the binary header extension
+func (p *encryptionParams) parse(b []byte) error {
+	if len(b) < encryptionParamsSize || b[0] == 0 || b[0] > scryptMaxLogN {
+		return ErrInvalidHeader
+	}
+	p.logN = b[0]
+	copy(p.salt[:], b[1:])
+	copy(p.nonce[:], b[1+saltSize:])
+	return nil
+}
+
+// aead derives the AES-256-GCM cipher from the passphrase using scrypt
+func (p encryptionParams) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, p.salt[:], 1<<p.logN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the message with the passphrase, returning the header describing the ciphertext and the ciphertext itself
+// The header must record every other extension of the payload, as it is authenticated along with it (see associatedData).
+func seal(h header, message, passphrase []byte) (header, []byte, error) {
+	params, err := newEncryptionParams()
+	if err != nil {
+		return header{}, nil, err
+	}
+	aead, err := params.aead(passphrase)
+	if err != nil {
+		return header{}, nil, err
+	}
+
+	h.flags |= flagEncrypted
+	h.encryption = params
+	h.length = uint32(len(message) + aead.Overhead())
+	h.checksum = 0 // the authentication tag supersedes the checksum
+	return h, aead.Seal(nil, params.nonce[:], message, h.associatedData()), nil
+}
+
+// open decrypts and authenticates the ciphertext described by the header
+func open(h header, ciphertext, passphrase []byte) ([]byte, error) {
+	aead, err := h.encryption.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	message, err := aead.Open(nil, h.encryption.nonce[:], ciphertext, h.associatedData())
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return message, nil
+}
+
+// associatedData returns the binary header authenticated along with encrypted payloads
+// The matrix and STC extensions are left out, as they are only chosen once the payload is sealed: modifying them
+// garbles the payload read, which then fails authentication.
+func (h header) associatedData() []byte {
+	h.flags &^= flagMatrix | flagSTC
+	return h.marshal()
+}
+
+// EncodeEncrypted encrypts the message with a key derived from the passphrase, and encodes it into the input image (see Encode)
+// The key is derived with scrypt using a random salt stored in the header, and the message is sealed with AES-256-GCM.
+// The message must fit in MaxEncodeSize(img) - EncryptionOverhead bytes.
+/*
+	Input:
+		writeBuffer *bytes.Buffer : the destination of the encoded image bytes
+		pictureInputFile image.Image : image data used in encoding
+		message []byte : byte slice of the message to be encoded
+		passphrase []byte : secret used to derive the encryption key
+	Output:
+		bytes buffer ( io.writter ) to create file, or send data.
+*/
+func EncodeEncrypted(writeBuffer *bytes.Buffer, pictureInputFile image.Image, message, passphrase []byte) error {
+	return NewEncoder(WithPassphrase(passphrase)).Encode(writeBuffer, pictureInputFile, message)
+}
+
+// DecodeEncrypted decodes a message encoded with EncodeEncrypted, and decrypts it with the passphrase
+// A wrong passphrase, or any modification of the encrypted message, is reported with ErrAuthentication.
+/*
+	Input:
+		pictureInputFile image.Image : image data used in decoding
+		passphrase []byte : secret used to derive the encryption key
+	Output:
+		message []byte decoded from image
+		err error : non nil if the image does not carry a valid encrypted payload
+*/
+func DecodeEncrypted(pictureInputFile image.Image, passphrase []byte) (message []byte, err error) {
+	return NewDecoder(WithPassphrase(passphrase)).Decode(pictureInputFile)
+}
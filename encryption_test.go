@@ -0,0 +1,142 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"testing"
+)
+
+func TestEncodeDecodeEncrypted(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+
+	w := new(bytes.Buffer)
+	err := EncodeEncrypted(w, newTestImage(60, 60), bitmessage, passphrase)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	msg, err := DecodeEncrypted(decodeImg, passphrase)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	if _, err = DecodeEncrypted(decodeImg, []byte("wrong passphrase")); err != ErrAuthentication {
+		log.Printf("Uncaught wrong passphrase: %v", err)
+		t.FailNow()
+	}
+
+	if _, err = DecodeAuto(decodeImg); err != ErrPassphraseRequired {
+		log.Printf("Uncaught encrypted message: %v", err)
+		t.FailNow()
+	}
+
+	// flip the least significant bit of the red channel of a pixel holding the ciphertext
+	tampered := imageToNRGBA(decodeImg)
+	pixel := (headerSize + encryptionParamsSize + 10) * 8 / 3
+	c := tampered.NRGBAAt(pixel/60, pixel%60)
+	c.R ^= 1
+	tampered.SetNRGBA(pixel/60, pixel%60, c)
+
+	if _, err = DecodeEncrypted(tampered, passphrase); err != ErrAuthentication {
+		log.Printf("Uncaught tampered message: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeEncryptedNotEncrypted(t *testing.T) {
+	w := new(bytes.Buffer)
+	err := Encode(w, newTestImage(60, 60), bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	if _, err = DecodeEncrypted(decodeImg, []byte("passphrase")); err != ErrNotEncrypted {
+		log.Printf("Uncaught message without encryption: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncryptedMessageTooLarge(t *testing.T) {
+	img := newTestImage(60, 60)
+	message := make([]byte, MaxEncodeSize(img)-EncryptionOverhead+1)
+
+	w := new(bytes.Buffer)
+	if err := EncodeEncrypted(w, img, message, []byte("passphrase")); err == nil {
+		log.Printf("Uncaught error: message too large for image")
+		t.FailNow()
+	}
+
+	w.Reset()
+	if err := EncodeEncrypted(w, img, message[1:], []byte("passphrase")); err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncryptionCostBounded(t *testing.T) {
+	params, err := newEncryptionParams()
+	if err != nil {
+		log.Printf("Error generating parameters %v", err)
+		t.FailNow()
+	}
+	b := params.marshal(nil)
+	if err := params.parse(b); err != nil {
+		log.Printf("Error parsing parameters %v", err)
+		t.FailNow()
+	}
+
+	b[0] = scryptMaxLogN + 1
+	if err := params.parse(b); err != ErrInvalidHeader {
+		log.Printf("Uncaught scrypt cost above the bound: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncryptedHeaderAuthenticated(t *testing.T) {
+	passphrase := []byte("passphrase")
+	message := bytes.Repeat([]byte("authenticated header "), 10)
+	h, payload, err := Options{Passphrase: passphrase, Compress: true, Depth: 2, ErrorCorrection: 4}.container(message)
+	if err != nil {
+		log.Printf("Error sealing message %v", err)
+		t.FailNow()
+	}
+	stream := append(h.marshal(), payload...)
+	stream = append(stream, make([]byte, 16)...) // room for lengths flipped upwards
+
+	if decoded, _, err := readMessage(&byteCarrier{stream}, h, passphrase); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+	for i := 0; i < h.size(); i++ {
+		tampered := append([]byte{}, stream...)
+		tampered[i] ^= 2
+		c := &byteCarrier{tampered}
+		th, err := readHeader(c)
+		if err != nil || checkLength(c, uint32(th.size()), th.length) != nil {
+			continue // refused before decryption
+		}
+		if _, _, err := readMessage(c, th, passphrase); err != ErrAuthentication {
+			log.Printf("expected ErrAuthentication with header byte %d flipped, got %v", i, err)
+			t.FailNow()
+		}
+	}
+}
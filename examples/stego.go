@@ -2,12 +2,12 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
 	"image"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/auyer/steganography"
 )
@@ -16,6 +16,21 @@ var pictureInputFile string
 var pictureOutputFile string
 var messageInputFile string
 var messageOutputFile string
+var passphrase string
+var key string
+var depth int
+var matching bool
+var matrix bool
+var cost string
+var texture int
+var compress bool
+var fec int
+var jpegMode bool
+var paletteMode bool
+var format string
+var lzw bool
+var chunk string
+var detect bool
 var decode bool
 var encode bool
 var help bool
@@ -32,6 +47,24 @@ func init() {
 	flag.StringVar(&messageInputFile, "mi", "", "Path to the message input file")
 	flag.StringVar(&messageOutputFile, "mo", "", "Path to the message output file")
 
+	flag.StringVar(&passphrase, "p", "", "Passphrase used to encrypt / decrypt the message")
+	flag.StringVar(&key, "k", "", "Secret key scattering the message over the image")
+	flag.IntVar(&depth, "depth", 1, "Number of low order bits used in each channel (1 to 4, or 8 for 16-bit images)")
+	flag.BoolVar(&matching, "matching", false, "Use LSB matching (randomly adding or subtracting 1) instead of LSB replacement")
+	flag.BoolVar(&matrix, "matrix", false, "Use matrix embedding (Hamming codes) to change fewer pixels")
+	flag.StringVar(&cost, "cost", "", "Embed with syndrome-trellis codes minimizing the cost of the changes: uniform or texture")
+	flag.IntVar(&texture, "texture", 0, "Only embed in pixels whose texture is at least the threshold, leaving flat regions untouched")
+	flag.BoolVar(&compress, "z", false, "Compress the message before embedding it")
+	flag.IntVar(&fec, "fec", 0, "Reed-Solomon parity bytes per 255 byte codeword protecting the message (0 to 128)")
+	flag.BoolVar(&jpegMode, "jpeg", false, "Embed in the DCT coefficients of a baseline JPEG input, writing a JPEG output")
+	flag.BoolVar(&paletteMode, "palette", false, "Embed in the pixels of a GIF or paletted PNG input keeping its palette, writing a GIF when the output ends in .gif")
+
+	flag.StringVar(&format, "format", "", "Write the output in the given lossless format (png, gif, bmp, tiff), or in the format of the input when set to auto")
+	flag.BoolVar(&lzw, "lzw", false, "Compress TIFF output with LZW")
+
+	flag.StringVar(&chunk, "chunk", "", "Store the message in a PNG chunk of the given type (a private type such as stGo, zTXt or iTXt) instead of the pixels")
+	flag.BoolVar(&detect, "detect", false, "List the chunks of a PNG file which may hide data")
+
 	flag.BoolVar(&help, "help", false, "Help")
 
 	flag.Parse()
@@ -52,6 +85,32 @@ func OpenImageFromPath(filename string) (image.Image, error) {
 	return img, nil
 }
 
+// decodeImage reads the message from the pixels of the image file
+func decodeImage(inFile *os.File) ([]byte, error) {
+	reader := bufio.NewReader(inFile)
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		log.Fatal("error decoding file", img)
+	}
+
+	opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), TextureThreshold: texture, ErrorCorrection: fec}
+	if paletteMode {
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			log.Fatalf("%s is not a paletted image", pictureInputFile)
+		}
+		return steganography.NewDecoder(steganography.WithOptions(steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), ErrorCorrection: fec})).DecodePaletted(paletted)
+	}
+	msg, corrected, err := steganography.NewDecoder(steganography.WithOptions(opts)).DecodeCorrected(img) // Read the message from the picture file, validating its header and checksum
+	if corrected > 0 {
+		log.Printf("Corrected %d damaged bytes", corrected)
+	}
+	if err == steganography.ErrInvalidHeader && key == "" && fec == 0 { // images encoded by older versions do not carry a header
+		msg, err = steganography.DecodeMessage(img)
+	}
+	return msg, err
+}
+
 func main() {
 	if encode {
 		message, err := os.ReadFile(messageInputFile) // Read the message from the message file (alternative to os.Open )
@@ -66,21 +125,98 @@ func main() {
 		}
 		defer inFile.Close()
 
+		if format != "" { // the format of the input is sniffed, and kept unless another one is requested
+			outFile, err := os.Create(pictureOutputFile)
+			if err != nil {
+				log.Fatalf("Error creating file %s: %v", pictureOutputFile, err)
+			}
+			defer outFile.Close()
+			opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), Depth: depth, Compress: compress, ErrorCorrection: fec}
+			if format != "auto" {
+				opts.Format = format
+			}
+			if lzw {
+				opts.TIFFCompression = steganography.TIFFLZW
+			}
+			if err := steganography.NewEncoder(steganography.WithOptions(opts)).EncodeFile(outFile, bufio.NewReader(inFile), message); err != nil {
+				log.Fatalf("Error encoding message into file  %v", err)
+			}
+			return
+		}
+
+		if chunk != "" { // the pixels are written unchanged, the message follows them
+			img, _, err := image.Decode(bufio.NewReader(inFile))
+			if err != nil {
+				log.Fatalf("Error opening file %v", err)
+			}
+			outFile, err := os.Create(pictureOutputFile)
+			if err != nil {
+				log.Fatalf("Error creating file %s: %v", pictureOutputFile, err)
+			}
+			defer outFile.Close()
+			opts := steganography.Options{Passphrase: []byte(passphrase), Compress: compress, ErrorCorrection: fec, PNGChunk: chunk}
+			if err := steganography.NewEncoder(steganography.WithOptions(opts)).EncodePNGChunk(outFile, img, message); err != nil {
+				log.Fatalf("Error encoding message into file  %v", err)
+			}
+			return
+		}
+
+		if jpegMode { // the coefficients are read from the JPEG file, without decoding its pixels
+			outFile, err := os.Create(pictureOutputFile)
+			if err != nil {
+				log.Fatalf("Error creating file %s: %v", pictureOutputFile, err)
+			}
+			defer outFile.Close()
+			opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), Compress: compress, ErrorCorrection: fec}
+			if err := steganography.NewEncoder(steganography.WithOptions(opts)).EncodeJPEG(outFile, bufio.NewReader(inFile), message); err != nil {
+				log.Fatalf("Error encoding message into file  %v", err)
+			}
+			return
+		}
+
 		reader := bufio.NewReader(inFile) // Reads binary data from picture file
 		img, _, err := image.Decode(reader)
 		if err != nil {
 			log.Fatalf("Error opening file %v", err)
 		}
-		encodedImg := new(bytes.Buffer)
-		err = steganography.Encode(encodedImg, img, message) // Calls library and Encodes the message into a new buffer
-		if err != nil {
-			log.Fatalf("Error encoding message into file  %v", err)
-		}
 		outFile, err := os.Create(pictureOutputFile) // Creates file to write the message into
 		if err != nil {
 			log.Fatalf("Error creating file %s: %v", pictureOutputFile, err)
 		}
-		bufio.NewWriter(outFile).Write(encodedImg.Bytes()) // writes file to disk
+		defer outFile.Close()
+
+		if paletteMode {
+			paletted, ok := img.(*image.Paletted)
+			if !ok {
+				log.Fatalf("%s is not a paletted image", pictureInputFile)
+			}
+			opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), Compress: compress, ErrorCorrection: fec}
+			encoder := steganography.NewEncoder(steganography.WithOptions(opts))
+			if strings.HasSuffix(strings.ToLower(pictureOutputFile), ".gif") {
+				err = encoder.EncodeGIF(outFile, paletted, message)
+			} else {
+				err = encoder.EncodePalettedPNG(outFile, paletted, message)
+			}
+			if err != nil {
+				log.Fatalf("Error encoding message into file  %v", err)
+			}
+			return
+		}
+
+		opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), Depth: depth, LSBMatching: matching, MatrixEmbedding: matrix, TextureThreshold: texture, Compress: compress, ErrorCorrection: fec}
+		switch cost {
+		case "":
+		case "uniform":
+			opts.Cost = steganography.UniformCost
+		case "texture":
+			opts.Cost = steganography.TextureCost
+		default:
+			log.Fatalf("Unknown cost function %s", cost)
+		}
+		err = steganography.NewEncoder(steganography.WithOptions(opts)).Encode(outFile, img, message) // Calls library and Encodes the message straight into the file
+		if err != nil {
+			log.Fatalf("Error encoding message into file  %v", err)
+		}
 
 	} else if decode {
 
@@ -90,16 +226,23 @@ func main() {
 		}
 		defer inFile.Close()
 
-		reader := bufio.NewReader(inFile)
-		img, _, err := image.Decode(reader)
+		var msg []byte
+		if jpegMode {
+			opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), ErrorCorrection: fec}
+			msg, err = steganography.NewDecoder(steganography.WithOptions(opts)).DecodeJPEG(bufio.NewReader(inFile))
+		} else if chunk != "" {
+			opts := steganography.Options{Passphrase: []byte(passphrase), ErrorCorrection: fec}
+			msg, err = steganography.NewDecoder(steganography.WithOptions(opts)).DecodePNGChunk(bufio.NewReader(inFile))
+		} else if format != "" {
+			opts := steganography.Options{Key: []byte(key), Passphrase: []byte(passphrase), ErrorCorrection: fec}
+			msg, err = steganography.NewDecoder(steganography.WithOptions(opts)).DecodeFile(bufio.NewReader(inFile))
+		} else {
+			msg, err = decodeImage(inFile)
+		}
 		if err != nil {
-			log.Fatal("error decoding file", img)
+			log.Fatalf("Error decoding message from file %v", err)
 		}
 
-		sizeOfMessage := steganography.GetMessageSizeFromImage(img) // Uses the library to check the message size
-
-		msg := steganography.Decode(sizeOfMessage, img) // Read the message from the picture file
-
 		// if the user specifies a location to write the message to...
 		if messageOutputFile != "" {
 			err := os.WriteFile(messageOutputFile, msg, 0644) // write the message to the given output file
@@ -112,6 +255,20 @@ func main() {
 				fmt.Printf("%c", msg[i])
 			}
 		}
+	} else if detect {
+		inFile, err := os.Open(pictureInputFile)
+		if err != nil {
+			log.Fatalf("Error opening file %s: %v", pictureInputFile, err)
+		}
+		defer inFile.Close()
+
+		chunks, err := steganography.DetectPNGChunks(bufio.NewReader(inFile))
+		if err != nil {
+			log.Fatalf("Error reading chunks from file %v", err)
+		}
+		for _, c := range chunks {
+			fmt.Printf("%-4s at %d, %d bytes: %s\n", c.Type, c.Offset, c.Length, c.Reason)
+		}
 	} else {
 		fmt.Println("How to use this script:")
 		fmt.Println("-i: the input image to encode in / decode from")
@@ -119,10 +276,14 @@ func main() {
 		fmt.Println("-e: take a message and encodes it into a specified location")
 		fmt.Println("-mi: input message to for the encoding option 			(ENCODING ONLY)")
 		fmt.Println("-o: where you would like to store the encodeded image		(ENCODING ONLY)")
+		fmt.Println("-depth: number of low order bits used in each channel, 1 to 4 or 8 for 16-bit images	(ENCODING ONLY)")
 		fmt.Println("\t+ EX: ./main -e -i plain.png -mi message.txt  -o secret.png")
 		fmt.Println()
 		fmt.Println("-d: take a picture and decodes the message from it")
 		fmt.Println("-mo: output message. Lempty for STDIO			(DECODING ONLY)")
+		fmt.Println()
+		fmt.Println("-p: passphrase used to encrypt the message when encoding, and decrypt it when decoding")
+		fmt.Println("-k: secret key scattering the message over the image, required again when decoding")
 		fmt.Println("\t+ EX: ./stego -d -i secret.png -mo secret.txt")
 		return
 	}
@@ -0,0 +1,241 @@
+package steganography
+
+import (
+	"errors"
+	"io"
+)
+
+// JPEG embedding follows F5 (Westfeld, 2001): each non zero AC coefficient carries one bit, its parity for positive
+// values and the opposite of its parity for negative ones. Coefficients are only ever moved towards zero, which keeps
+// the histogram shape JSteg-style embedding betrays. When a coefficient shrinks to zero, decoders skip it, so the
+// bit is embedded again in the next coefficient. DC coefficients and zero coefficients are never used.
+
+// ErrUnsupportedJPEGOption is returned when JPEG embedding is combined with options working on pixels
+var ErrUnsupportedJPEGOption = errors.New("JPEG embedding only supports key, passphrase, compression and error correction options")
+
+// jpegCarrier stores a stream in the non zero AC coefficients of a JPEG image, one bit per coefficient
+// Coefficients are visited component after component, block after block, in zigzag order within a block,
+// or in a pseudo-random order derived from a secret key.
+type jpegCarrier struct {
+	img      *jpegImage
+	key      []byte // nil walks the coefficients in order
+	overflow *bool  // set when the stream did not fit, as shrinkage makes the capacity unknown until embedding
+}
+
+// newJPEGCarrier creates the carrier embedding in the coefficients of the image
+func newJPEGCarrier(img *jpegImage, key []byte) jpegCarrier {
+	return jpegCarrier{img: img, key: key, overflow: new(bool)}
+}
+
+// slots returns the number of AC coefficients of the blocks covering the image
+func (c jpegCarrier) slots() int {
+	n := 0
+	for comp := range c.img.components {
+		w, h := c.img.blocks(comp)
+		n += w * h * (zigzagBlock - 1)
+	}
+	return n
+}
+
+// coefficient returns the AC coefficient of the given slot
+func (c jpegCarrier) coefficient(slot int) *int16 {
+	for comp := range c.img.components {
+		w, h := c.img.blocks(comp)
+		if n := w * h * (zigzagBlock - 1); slot >= n {
+			slot -= n
+			continue
+		}
+		block := slot / (zigzagBlock - 1)
+		return &c.img.block(comp, block%w, block/w)[1+slot%(zigzagBlock-1)]
+	}
+	return nil
+}
+
+// coefficients returns a function yielding the non zero AC coefficients in traversal order, and nil once all were visited
+func (c jpegCarrier) coefficients() func() *int16 {
+	slots := c.slots()
+	var order traversal = new(sequentialOrder)
+	if c.key != nil {
+		order = newPermutation(c.key, slots)
+	}
+	drawn := 0
+	return func() *int16 {
+		for drawn < slots {
+			coef := c.coefficient(order.next())
+			drawn++
+			if *coef != 0 {
+				return coef
+			}
+		}
+		return nil
+	}
+}
+
+// coefficientBit returns the bit carried by a non zero coefficient
+func coefficientBit(coef int16) byte {
+	if coef < 0 {
+		return byte(1 - -coef&1)
+	}
+	return byte(coef & 1)
+}
+
+// capacity returns the number of bytes of the non zero coefficients, an upper bound as embedding may shrink some of them
+func (c jpegCarrier) capacity() int {
+	n := 0
+	for next := newJPEGCarrier(c.img, nil).coefficients(); next() != nil; { // the count does not depend on the order
+		n++
+	}
+	return n / 8
+}
+
+// expectedCapacity returns the number of bytes the image is expected to hold, as half the coefficients of magnitude 1
+// shrink to zero when embedding, and then carry nothing
+func (c jpegCarrier) expectedCapacity() int {
+	n := 0
+	for next := newJPEGCarrier(c.img, nil).coefficients(); ; {
+		coef := next()
+		if coef == nil {
+			break
+		}
+		if *coef == 1 || *coef == -1 {
+			n++
+		} else {
+			n += 2
+		}
+	}
+	return n / 16
+}
+
+func (c jpegCarrier) write(data []byte) {
+	next := c.coefficients()
+	bits := len(data) * 8
+	for i := 0; i < bits; {
+		coef := next()
+		if coef == nil {
+			*c.overflow = true
+			return
+		}
+		if coefficientBit(*coef) == getBitFromByte(data[i/8], i%8) {
+			i++
+			continue
+		}
+		if *coef > 0 {
+			*coef--
+		} else {
+			*coef++
+		}
+		if *coef != 0 {
+			i++
+		} // else the coefficient shrank, and the bit goes to the next one
+	}
+}
+
+func (c jpegCarrier) read(offset, length uint32) []byte {
+	next := c.coefficients()
+	message := make([]byte, length)
+
+	skip := int(offset) * 8
+	for i := 0; i < skip+len(message)*8; i++ {
+		coef := next()
+		if coef == nil {
+			break
+		}
+		if i >= skip {
+			j := i - skip
+			message[j/8] = setBitInByte(message[j/8], uint32(j%8), coefficientBit(*coef))
+		}
+	}
+	return message
+}
+
+// validateJPEG checks the options can be used to embed messages in JPEG images, which accept the options paletted images do
+func (opts Options) validateJPEG() error {
+	if err := opts.validatePalette(); err != ErrUnsupportedPaletteOption {
+		return err
+	}
+	return ErrUnsupportedJPEGOption
+}
+
+// jpegCarrier returns the carrier embedding in the coefficients of the image with these options, and the coefficient carrier it wraps
+func (opts Options) jpegCarrier(img *jpegImage) (carrier, jpegCarrier) {
+	var key []byte
+	if len(opts.Key) > 0 {
+		key = opts.Key
+	}
+	coefs := newJPEGCarrier(img, key)
+	return opts.protect(coefs), coefs
+}
+
+// EncodeJPEG encodes the message into the quantized DCT coefficients of the baseline JPEG image read from cover,
+// and writes the resulting JPEG to w. The image is not decoded to pixels, so it is not quantized again.
+// Options working on samples are refused with ErrUnsupportedJPEGOption, see validatePalette.
+func (e *Encoder) EncodeJPEG(w io.Writer, cover io.Reader, message []byte) error {
+	opts := e.opts
+	if err := opts.validateJPEG(); err != nil {
+		return err
+	}
+	img, err := readJPEG(cover)
+	if err != nil {
+		return err
+	}
+	h, message, err := opts.container(message)
+	if err != nil {
+		return err
+	}
+
+	c, coefs := opts.jpegCarrier(img)
+	if messageCapacity(c.capacity()) < uint32(h.size()-headerSize+len(message)) {
+		return ErrMessageTooLarge
+	}
+	c.write(append(h.marshal(), message...))
+	if *coefs.overflow {
+		return ErrMessageTooLarge
+	}
+	return img.write(w)
+}
+
+// MaxEncodeSizeJPEG estimates how many bytes can be stored in the JPEG image read from cover by EncodeJPEG
+// The exact capacity depends on the message, as coefficients shrinking to zero carry nothing.
+func (e *Encoder) MaxEncodeSizeJPEG(cover io.Reader) (uint32, error) {
+	opts := e.opts
+	if err := opts.validateJPEG(); err != nil {
+		return 0, err
+	}
+	img, err := readJPEG(cover)
+	if err != nil {
+		return 0, err
+	}
+
+	capacity := newJPEGCarrier(img, nil).expectedCapacity()
+	if opts.ErrorCorrection > 0 {
+		capacity = fecCapacity(capacity, opts.ErrorCorrection)
+	}
+	size, overhead := messageCapacity(capacity), uint32(opts.overhead())
+	if size < overhead {
+		return 0, nil
+	}
+	return size - overhead, nil
+}
+
+// DecodeJPEG returns the message embedded by EncodeJPEG in the JPEG image read from r
+func (d *Decoder) DecodeJPEG(r io.Reader) (message []byte, err error) {
+	opts := d.opts
+	if err = opts.validateJPEG(); err != nil {
+		return nil, err
+	}
+	img, err := readJPEG(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, _ := opts.jpegCarrier(img)
+	h, err := readHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	if h.embeddingDepth() != 1 || h.errorCorrection() != opts.ErrorCorrection {
+		return nil, ErrInvalidHeader
+	}
+	message, _, err = readMessage(c, h, opts.passphrase())
+	return message, err
+}
@@ -0,0 +1,121 @@
+package steganography
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestEncodeDecodeJPEG(t *testing.T) {
+	stegosaurus, err := ioutil.ReadFile("./examples/stegosaurus.jpg")
+	if err != nil {
+		log.Printf("Error reading file %v", err)
+		t.FailNow()
+	}
+	message := []byte("Stegosaurus is one of the most easily identifiable dinosaur genera")
+
+	for _, opts := range []Options{
+		{},
+		{Key: []byte("key")},
+		{Passphrase: []byte("passphrase"), Compress: true},
+		{Key: []byte("key"), ErrorCorrection: 16},
+	} {
+		var buf bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).EncodeJPEG(&buf, bytes.NewReader(stegosaurus), message); err != nil {
+			log.Printf("Error encoding with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			log.Printf("encoded JPEG can not be decoded: %v", err)
+			t.FailNow()
+		}
+		decoded, err := NewDecoder(WithOptions(opts)).DecodeJPEG(bytes.NewReader(buf.Bytes()))
+		if err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncodeJPEGCoefficients(t *testing.T) {
+	cover := jpegCover(texturedImage(120, 80), 90)
+	message := bytes.Repeat([]byte("coefficients"), 20)
+	var buf bytes.Buffer
+	if err := NewEncoder().EncodeJPEG(&buf, bytes.NewReader(cover), message); err != nil {
+		log.Printf("Error encoding message %v", err)
+		t.FailNow()
+	}
+	stegoData := buf.Bytes()
+	if decoded, err := NewDecoder().DecodeJPEG(bytes.NewReader(stegoData)); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+
+	before, err := readJPEG(bytes.NewReader(cover))
+	if err != nil {
+		log.Printf("Error reading JPEG %v", err)
+		t.FailNow()
+	}
+	after, err := readJPEG(bytes.NewReader(stegoData))
+	if err != nil {
+		log.Printf("Error reading JPEG %v", err)
+		t.FailNow()
+	}
+	changed := 0
+	for c, comp := range before.components {
+		for i, coef := range comp.coefs {
+			stego := after.components[c].coefs[i]
+			if i%zigzagBlock == 0 && stego != coef {
+				log.Printf("DC coefficient %d of component %d changed", i/zigzagBlock, c)
+				t.FailNow()
+			}
+			if stego == coef {
+				continue
+			}
+			changed++
+			if coef == 0 || abs(int(stego)) != abs(int(coef))-1 || (stego != 0 && (stego > 0) != (coef > 0)) {
+				log.Printf("coefficient changed from %d to %d, instead of moving towards zero", coef, stego)
+				t.FailNow()
+			}
+		}
+	}
+	if changed == 0 {
+		log.Print("no coefficient changed")
+		t.FailNow()
+	}
+}
+
+func TestEncodeJPEGCapacity(t *testing.T) {
+	cover := jpegCover(texturedImage(64, 48), 75)
+	size, err := NewEncoder().MaxEncodeSizeJPEG(bytes.NewReader(cover))
+	if err != nil || size == 0 {
+		log.Printf("expected some capacity, got %d, %v", size, err)
+		t.FailNow()
+	}
+
+	var buf bytes.Buffer
+	message := bytes.Repeat([]byte{0x5a}, int(size)/2)
+	if err := NewEncoder().EncodeJPEG(&buf, bytes.NewReader(cover), message); err != nil {
+		log.Printf("message of half the estimated capacity does not fit: %v", err)
+		t.FailNow()
+	}
+	message = bytes.Repeat([]byte{0x5a}, int(size)*2)
+	if err := NewEncoder().EncodeJPEG(&buf, bytes.NewReader(cover), message); err != ErrMessageTooLarge {
+		log.Printf("expected ErrMessageTooLarge, got %v", err)
+		t.FailNow()
+	}
+	if err := NewEncoder(WithDepth(2)).EncodeJPEG(&buf, bytes.NewReader(cover), []byte("m")); err != ErrUnsupportedJPEGOption {
+		log.Printf("expected ErrUnsupportedJPEGOption, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeJPEGWithoutMessage(t *testing.T) {
+	cover := jpegCover(texturedImage(64, 48), 75)
+	if _, err := NewDecoder().DecodeJPEG(bytes.NewReader(cover)); err == nil {
+		log.Print("expected an error decoding a JPEG without message")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,323 @@
+package steganography
+
+import (
+	"errors"
+)
+
+// Forward error correction protects the stream with Reed-Solomon codes over GF(2^8), so that messages survive a bounded
+// number of flipped bits. The fixed part of the header is encoded in its own codeword, so that a damaged length can
+// be corrected before the rest of the stream is located. The header extensions and the payload follow, split in
+// codewords of at most 255 bytes, each holding parity bytes able to correct half as many corrupted bytes.
+
+const (
+	// rsBlockSize is the maximum size of a Reed-Solomon codeword over GF(2^8)
+	rsBlockSize = 255
+	// MaxErrorCorrection is the maximum number of parity bytes per codeword, see Options.ErrorCorrection
+	MaxErrorCorrection = 128
+)
+
+var (
+	// ErrInvalidErrorCorrection is returned when Options.ErrorCorrection is not between 0 and MaxErrorCorrection
+	ErrInvalidErrorCorrection = errors.New("error correction must be between 0 and 128 parity bytes")
+	// ErrErrorCorrectionCoding is returned when error correction is combined with matrix or cost based embedding
+	ErrErrorCorrectionCoding = errors.New("error correction can not be combined with matrix or cost based embedding")
+	// ErrTooManyErrors is returned when the image holds more corrupted bytes than the error correction can repair
+	ErrTooManyErrors = errors.New("too many errors to correct")
+)
+
+// gfExp and gfLog are the exponential and logarithm tables of GF(2^8), generated by 2 modulo x^8+x^4+x^3+x^2+1
+// gfExp is doubled in length, so that products can be looked up without reducing the sum of the logarithms.
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [2 * rsBlockSize]byte, log [256]int) {
+	x := 1
+	for i := 0; i < rsBlockSize; i++ {
+		exp[i] = byte(x)
+		exp[i+rsBlockSize] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}
+
+// gfMul multiplies two elements of GF(2^8)
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+// gfDiv divides a by the non zero element b of GF(2^8)
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+rsBlockSize-gfLog[b]]
+}
+
+// gfPow returns 2^e in GF(2^8)
+func gfPow(e int) byte {
+	e %= rsBlockSize
+	if e < 0 {
+		e += rsBlockSize
+	}
+	return gfExp[e]
+}
+
+// rsGenerator returns the coefficients, highest degree first, of the generator polynomial (x-1)(x-2)...(x-2^(parity-1))
+func rsGenerator(parity int) []byte {
+	g := []byte{1}
+	for j := 0; j < parity; j++ {
+		next := make([]byte, len(g)+1)
+		for i, coef := range g {
+			next[i] ^= coef
+			next[i+1] ^= gfMul(coef, gfPow(j))
+		}
+		g = next
+	}
+	return g
+}
+
+// rsEncode returns the codeword made of the data followed by its parity bytes
+func rsEncode(data []byte, parity int) []byte {
+	g := rsGenerator(parity)
+	remainder := make([]byte, parity)
+	for _, d := range data {
+		coef := d ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[parity-1] = 0
+		for j := 0; j < parity; j++ {
+			remainder[j] ^= gfMul(g[j+1], coef)
+		}
+	}
+	return append(append([]byte{}, data...), remainder...)
+}
+
+// rsSyndromes returns the syndromes of the codeword, the values of its polynomial at 2^0 to 2^(parity-1)
+func rsSyndromes(codeword []byte, parity int) ([]byte, bool) {
+	syndromes := make([]byte, parity)
+	clean := true
+	for j := range syndromes {
+		var s byte
+		for _, c := range codeword {
+			s = gfMul(s, gfPow(j)) ^ c
+		}
+		syndromes[j] = s
+		clean = clean && s == 0
+	}
+	return syndromes, clean
+}
+
+// rsDecode corrects the codeword in place, returning the number of corrected bytes
+// It reports false when the codeword holds more errors than the parity bytes can correct.
+func rsDecode(codeword []byte, parity int) (int, bool) {
+	syndromes, clean := rsSyndromes(codeword, parity)
+	if clean {
+		return 0, true
+	}
+
+	// Berlekamp-Massey: find the error locator polynomial, lowest degree first
+	locator, previous := []byte{1}, []byte{1}
+	errs, shift, last := 0, 1, byte(1)
+	for n := 0; n < parity; n++ {
+		d := syndromes[n]
+		for i := 1; i <= errs && i < len(locator); i++ {
+			d ^= gfMul(locator[i], syndromes[n-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		coef := gfDiv(d, last)
+		size := len(previous) + shift
+		if size < len(locator) {
+			size = len(locator)
+		}
+		updated := make([]byte, size)
+		copy(updated, locator)
+		for i, p := range previous {
+			updated[i+shift] ^= gfMul(coef, p)
+		}
+		if 2*errs <= n {
+			previous, last = locator, d
+			errs = n + 1 - errs
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = updated
+	}
+	if 2*errs > parity {
+		return 0, false
+	}
+
+	// the error evaluator polynomial is the product of the syndromes and the locator, modulo x^parity
+	evaluator := make([]byte, parity)
+	for i := range evaluator {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= gfMul(locator[j], syndromes[i-j])
+		}
+	}
+
+	// Chien search for the roots of the locator, and Forney's formula for the error values
+	n := len(codeword)
+	corrected := 0
+	for i := range codeword {
+		x := gfPow(n - 1 - i)
+		xInv := gfPow(-(n - 1 - i))
+		if evaluate(locator, xInv) != 0 {
+			continue
+		}
+		var derivative byte
+		for j := 1; j < len(locator); j += 2 {
+			derivative ^= gfMul(locator[j], gfPow(-(n-1-i)*(j-1)))
+		}
+		if derivative == 0 {
+			return 0, false
+		}
+		codeword[i] ^= gfMul(x, gfDiv(evaluate(evaluator, xInv), derivative))
+		corrected++
+	}
+	if corrected != errs {
+		return 0, false
+	}
+	if _, clean = rsSyndromes(codeword, parity); !clean {
+		return 0, false
+	}
+	return corrected, true
+}
+
+// evaluate returns the value at x of the polynomial given lowest degree first
+func evaluate(poly []byte, x byte) byte {
+	var y byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ poly[i]
+	}
+	return y
+}
+
+// fecCarrier protects the stream of another carrier with Reed-Solomon codes, see Options.ErrorCorrection
+// Decoded codewords are cached, so the header and the payload can be read in turn without decoding them twice.
+type fecCarrier struct {
+	carrier
+	parity int
+	state  *fecState
+}
+
+// fecState holds the codewords decoded by a fecCarrier
+type fecState struct {
+	blocks    [][]byte // data of the decoded codewords, in stream order
+	corrected int      // number of corrupted bytes corrected so far
+	failed    bool     // some codeword could not be corrected
+}
+
+// newFECCarrier wraps the carrier, adding the given number of parity bytes to each codeword
+func newFECCarrier(c carrier, parity int) fecCarrier {
+	return fecCarrier{carrier: c, parity: parity, state: new(fecState)}
+}
+
+// blockSizes returns the size of the data of each codeword of a stream of the given length
+func (c fecCarrier) blockSizes(length int) []int {
+	sizes := []int{headerSize}
+	for rest := length - headerSize; rest > 0; rest -= rsBlockSize - c.parity {
+		if rest > rsBlockSize-c.parity {
+			sizes = append(sizes, rsBlockSize-c.parity)
+		} else {
+			sizes = append(sizes, rest)
+		}
+	}
+	return sizes
+}
+
+func (c fecCarrier) capacity() int {
+	return fecCapacity(c.carrier.capacity(), c.parity)
+}
+
+// fecCapacity returns the number of bytes a stream of the given raw capacity holds once protected by the parity bytes
+func fecCapacity(capacity, parity int) int {
+	raw := capacity - headerSize - parity
+	if raw < 0 {
+		return 0
+	}
+	data := headerSize + raw/rsBlockSize*(rsBlockSize-parity)
+	if last := raw%rsBlockSize - parity; last > 0 {
+		data += last
+	}
+	return data
+}
+
+func (c fecCarrier) write(data []byte) {
+	var encoded []byte
+	for _, size := range c.blockSizes(len(data)) {
+		encoded = append(encoded, rsEncode(data[:size], c.parity)...)
+		data = data[size:]
+	}
+	c.carrier.write(encoded)
+}
+
+func (c fecCarrier) read(offset, length uint32) []byte {
+	stream := c.decode(int(offset) + int(length))
+	message := make([]byte, length)
+	if int(offset) < len(stream) {
+		copy(message, stream[offset:])
+	}
+	return message
+}
+
+// decode returns the first n bytes of the corrected stream, or fewer when the header does not describe a stream that long
+// The length of the stream, and so the size of its codewords, is read from the fixed part of the header.
+func (c fecCarrier) decode(n int) []byte {
+	c.decodeBlocks(n, []int{headerSize})
+	if h, err := parseHeader(c.state.blocks[0]); err == nil {
+		length := h.size() + int(h.length)
+		if capacity := c.capacity(); length > capacity {
+			length = capacity // the payload will be rejected, only the header extensions can be read
+		}
+		c.decodeBlocks(n, c.blockSizes(length))
+	}
+
+	var stream []byte
+	for _, block := range c.state.blocks {
+		stream = append(stream, block...)
+	}
+	return stream
+}
+
+// decodeBlocks decodes the codewords of the given data sizes holding the first n bytes of the stream, unless already cached
+func (c fecCarrier) decodeBlocks(n int, sizes []int) {
+	blocks, end, encodedEnd := 0, 0, 0
+	for _, size := range sizes {
+		if end >= n && blocks > 0 {
+			break
+		}
+		blocks++
+		end += size
+		encodedEnd += size + c.parity
+	}
+	if blocks <= len(c.state.blocks) {
+		return
+	}
+
+	raw := c.carrier.read(0, uint32(encodedEnd))
+	position := 0
+	for i, size := range sizes[:blocks] {
+		codeword := make([]byte, size+c.parity)
+		if position < len(raw) {
+			copy(codeword, raw[position:])
+		}
+		position += len(codeword)
+		if i < len(c.state.blocks) {
+			continue
+		}
+		if corrected, ok := rsDecode(codeword, c.parity); ok {
+			c.state.corrected += corrected
+		} else {
+			c.state.failed = true
+		}
+		c.state.blocks = append(c.state.blocks, codeword[:size])
+	}
+}
@@ -0,0 +1,135 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range []struct{ size, parity int }{{14, 4}, {14, 32}, {100, 16}, {rsBlockSize - 2, 2}, {rsBlockSize - 128, 128}} {
+		data := make([]byte, test.size)
+		rng.Read(data)
+		codeword := rsEncode(data, test.parity)
+		if _, clean := rsSyndromes(codeword, test.parity); !clean {
+			log.Printf("codeword of %d bytes with %d parity bytes has non zero syndromes", test.size, test.parity)
+			t.FailNow()
+		}
+
+		for errs := 0; errs <= test.parity/2+1; errs++ {
+			damaged := append([]byte{}, codeword...)
+			for _, i := range rng.Perm(len(damaged))[:errs] {
+				damaged[i] ^= byte(1 + rng.Intn(255))
+			}
+			corrected, ok := rsDecode(damaged, test.parity)
+			if errs <= test.parity/2 {
+				if !ok || corrected != errs || !bytes.Equal(damaged, codeword) {
+					log.Printf("%d errors not corrected with %d parity bytes: %v %d", errs, test.parity, ok, corrected)
+					t.FailNow()
+				}
+			} else if ok && bytes.Equal(damaged, codeword) {
+				log.Printf("%d errors corrected with only %d parity bytes", errs, test.parity)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+// flipSamples flips the least significant bit of the samples of the sequential order from first to last, excluded
+func flipSamples(img *image.NRGBA, first, last int) {
+	height := img.Bounds().Dy()
+	for s := first; s < last; s++ {
+		pixel := s / 3
+		img.Pix[img.PixOffset(pixel/height, pixel%height)+s%3] ^= 1
+	}
+}
+
+func TestEncodeDecodeWithErrorCorrection(t *testing.T) {
+	cover := newTestImage(100, 100)
+	message := bytes.Repeat(bitmessage, 3)
+	opts := Options{ErrorCorrection: 16}
+
+	stego, err := EmbedImage(cover, message, opts)
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	damaged := stego.(*image.NRGBA)
+
+	msg, corrected, err := NewDecoder(WithOptions(opts)).DecodeCorrected(damaged)
+	if err != nil || corrected != 0 || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding intact message: %v %d", err, corrected)
+		t.FailNow()
+	}
+
+	// damage the length in the header, and a few bytes of the payload
+	flipSamples(damaged, 8*6, 8*6+1)
+	flipSamples(damaged, 8*100, 8*100+3)
+	flipSamples(damaged, 8*400+5, 8*400+6)
+	msg, corrected, err = NewDecoder(WithErrorCorrection(16)).DecodeCorrected(damaged)
+	if err != nil || corrected != 3 || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding damaged message: %v %d", err, corrected)
+		t.FailNow()
+	}
+
+	// 40 bytes of the second codeword exceed its 8 correctable bytes
+	flipSamples(damaged, 8*100, 8*140)
+	if _, _, err = NewDecoder(WithOptions(opts)).DecodeCorrected(damaged); err != ErrTooManyErrors {
+		log.Printf("Uncaught unrecoverable damage: %v", err)
+		t.FailNow()
+	}
+
+	// the options must match
+	if _, err = DecodeAuto(stego); err != ErrInvalidHeader {
+		log.Printf("Decoded an error corrected message without error correction: %v", err)
+		t.FailNow()
+	}
+	plain, _ := EmbedImage(cover, message, Options{})
+	if _, err = NewDecoder(WithOptions(opts)).Decode(plain); err != ErrInvalidHeader {
+		log.Printf("Decoded a plain message with error correction: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestErrorCorrectionOptions(t *testing.T) {
+	cover := newTestImage(100, 100)
+	for _, opts := range []Options{
+		{ErrorCorrection: 8, Key: []byte("secret key"), Depth: 2},
+		{ErrorCorrection: 32, Passphrase: []byte("passphrase"), Compress: true, Channels: RGBA},
+		{ErrorCorrection: MaxErrorCorrection},
+	} {
+		size := NewEncoder(WithOptions(opts)).MaxEncodeSize(cover)
+		message := make([]byte, size)
+		rand.New(rand.NewSource(2)).Read(message)
+		stego, err := EmbedImage(cover, message, opts)
+		if err != nil {
+			log.Printf("Error embedding %d bytes: %v", size, err)
+			t.FailNow()
+		}
+		if msg, err := NewDecoder(WithOptions(opts)).Decode(stego); err != nil || !bytes.Equal(msg, message) {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if _, err = EmbedImage(cover, append(message, 0), opts); err != ErrMessageTooLarge && !opts.Compress {
+			log.Printf("Uncaught message exceeding the capacity: %v", err)
+			t.FailNow()
+		}
+	}
+
+	for _, opts := range []Options{
+		{ErrorCorrection: -1},
+		{ErrorCorrection: MaxErrorCorrection + 1},
+	} {
+		if _, err := EmbedImage(cover, bitmessage, opts); err != ErrInvalidErrorCorrection {
+			log.Printf("Uncaught invalid error correction: %v", err)
+			t.FailNow()
+		}
+	}
+	if _, err := EmbedImage(cover, bitmessage, Options{ErrorCorrection: 8, MatrixEmbedding: true}); err != ErrErrorCorrectionCoding {
+		log.Printf("Uncaught error correction with matrix embedding: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,210 @@
+package steganography
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// EncodeFile and DecodeFile work on encoded image files rather than decoded images: the format of the cover is sniffed
+// with image.DecodeConfig, and the stego image is written back in the same container, or in the format requested by
+// Options.Format. Only lossless writers are used, as lossy compression destroys the low order bits carrying the message.
+
+var (
+	// ErrLossyFormat is returned when the requested output format would not preserve the samples carrying the message
+	ErrLossyFormat = errors.New("output format is lossy and would destroy the message, use a lossless format such as PNG")
+	// ErrUnsupportedFormat is returned when no lossless writer is available for the requested output format, or the format
+	// can not hold the image as it is (truecolor images as GIF)
+	ErrUnsupportedFormat = errors.New("no lossless writer for the output format")
+)
+
+// formatWriter writes the stego image in a lossless format
+type formatWriter func(w io.Writer, img image.Image, opts Options) error
+
+// formatWriters holds the writers of the formats EncodeFile can write, by format name
+var formatWriters = map[string]formatWriter{
+	"png":  writePNG,
+	"gif":  writeGIF,
+	"bmp":  writeBMP,
+	"tiff": writeTIFF,
+}
+
+// lossyFormats holds the formats whose encoders do not preserve the samples of the image, by name and common alias
+// WebP is refused as well: it has a lossless mode, but no encoder is available to write it.
+var lossyFormats = map[string]bool{
+	"jpeg": true,
+	"jpg":  true,
+	"webp": true,
+}
+
+// pngEncoder returns the PNG encoder configured by the options
+// The PNG color type and bit depth follow the type of the image, which Embed keeps for 16-bit, grayscale and opaque covers.
+func (opts Options) pngEncoder() *png.Encoder {
+	return &png.Encoder{CompressionLevel: opts.PNGCompression}
+}
+
+func writePNG(w io.Writer, img image.Image, opts Options) error {
+	return opts.pngEncoder().Encode(w, img)
+}
+
+// writeGIF writes paletted images as GIF, which can not hold truecolor images without quantizing them
+func writeGIF(w io.Writer, img image.Image, opts Options) error {
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		return ErrUnsupportedFormat
+	}
+	return gif.Encode(w, paletted, nil)
+}
+
+// outputFormat returns the format the stego image of a cover in the given format is written in, and its writer
+func (opts Options) outputFormat(format string) (string, formatWriter, error) {
+	if opts.Format != "" {
+		format = strings.ToLower(opts.Format)
+	}
+	if lossyFormats[format] {
+		return format, nil, ErrLossyFormat
+	}
+	writer, ok := formatWriters[format]
+	if !ok {
+		return format, nil, ErrUnsupportedFormat
+	}
+	return format, writer, nil
+}
+
+// paletted reports whether the message is embedded in the image with palette embedding, keeping its palette
+func (opts Options) paletted(img image.Image) (*image.Paletted, bool) {
+	paletted, ok := img.(*image.Paletted)
+	return paletted, ok && opts.validatePalette() == nil
+}
+
+// opaquePalette reports whether all the colors of the palette are opaque
+func opaquePalette(palette color.Palette) bool {
+	for _, c := range palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// fullPalette returns a copy of the paletted image whose palette is padded with black to 256 colors, as BMP and TIFF
+// files store it. Padding it before embedding keeps the order of the colors used by palette embedding.
+func fullPalette(img *image.Paletted) *image.Paletted {
+	if len(img.Palette) >= 256 {
+		return img
+	}
+	padded := *img
+	padded.Palette = make(color.Palette, 256)
+	copy(padded.Palette, img.Palette)
+	for i := len(img.Palette); i < len(padded.Palette); i++ {
+		padded.Palette[i] = color.RGBA{0, 0, 0, 0xff}
+	}
+	return &padded
+}
+
+// animated reports whether the image file in the given format holds several frames
+func animated(format string, data []byte) bool {
+	switch format {
+	case "gif":
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		return err == nil && len(animation.Image) > 1
+	case "png":
+		return animatedPNG(data)
+	}
+	return false
+}
+
+// EncodeFile encodes the message into the image file read from r, and writes the result to w in the same format,
+// or in the format selected by Options.Format
+// JPEG covers written as JPEG are embedded in their coefficients (see EncodeJPEG), and paletted covers, such as GIF or
+// paletted PNG files, keep their palette (see EmbedPaletted) unless the options only apply to truecolor images.
+// Animated GIF and PNG covers written in their own format carry the message across all their frames (see EmbedAnimatedGIF and EncodeAPNG).
+// Other covers are embedded in their samples (see Embed). Lossy output formats are refused with ErrLossyFormat.
+func (e *Encoder) EncodeFile(w io.Writer, r io.Reader, message []byte) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if requested := strings.ToLower(e.opts.Format); format == "jpeg" && (requested == "" || requested == "jpeg" || requested == "jpg") {
+		return e.EncodeJPEG(w, bytes.NewReader(data), message)
+	}
+	output, writer, err := e.opts.outputFormat(format)
+	if err != nil {
+		return err
+	}
+	if output == format && animated(format, data) {
+		if format == "gif" {
+			animation, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			return e.EncodeAnimatedGIF(w, animation, message)
+		}
+		return e.EncodeAPNG(w, bytes.NewReader(data), message)
+	}
+	cover, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if format == "bmp" || output == "bmp" {
+		cover = bmpGray(cover)
+	}
+
+	var stego image.Image
+	if paletted, ok := e.opts.paletted(cover); ok {
+		if output == "bmp" || output == "tiff" {
+			paletted = fullPalette(paletted)
+		}
+		stego, err = e.EmbedPaletted(paletted, message)
+	} else {
+		stego, err = e.Embed(cover, message)
+	}
+	if err != nil {
+		return err
+	}
+	return writer(w, stego, e.opts)
+}
+
+// DecodeFile returns the message embedded by EncodeFile in the image file read from r
+func (d *Decoder) DecodeFile(r io.Reader) (message []byte, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "jpeg" {
+		return d.DecodeJPEG(bytes.NewReader(data))
+	}
+	if format == "bmp" {
+		img = bmpGray(img)
+	}
+	if animated(format, data) {
+		if format == "gif" {
+			animation, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return d.DecodeAnimatedGIF(animation)
+		}
+		return d.DecodeAPNG(bytes.NewReader(data))
+	}
+	if paletted, ok := d.opts.paletted(img); ok {
+		return d.DecodePaletted(paletted)
+	}
+	return d.Decode(img)
+}
@@ -0,0 +1,148 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"log"
+	"testing"
+)
+
+// pngColorType returns the bit depth and color type recorded in the IHDR chunk of a PNG file
+func pngColorType(data []byte) (depth, colorType byte) {
+	if len(data) < 26 || string(data[12:16]) != "IHDR" {
+		return 0, 0
+	}
+	return data[24], data[25]
+}
+
+func TestEncodeFileKeepsFormat(t *testing.T) {
+	rgb := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	copy(rgb.Pix, newTestImage(40, 30).Pix)
+	gray := image.NewGray(image.Rect(0, 0, 40, 30))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i)
+	}
+
+	message := []byte("same container")
+	for _, cover := range []image.Image{rgb, newTestImage(40, 30), gray, newTestImage16(40, 30), newPalettedImage(40, 30, palette.Plan9)} {
+		var in bytes.Buffer
+		if err := png.Encode(&in, cover); err != nil {
+			log.Printf("Error encoding PNG %v", err)
+			t.FailNow()
+		}
+		var out bytes.Buffer
+		if err := NewEncoder().EncodeFile(&out, bytes.NewReader(in.Bytes()), message); err != nil {
+			log.Printf("Error encoding a %T file %v", cover, err)
+			t.FailNow()
+		}
+		depth, colorType := pngColorType(in.Bytes())
+		if d, c := pngColorType(out.Bytes()); d != depth || c != colorType {
+			log.Printf("%T: PNG bit depth %d and color type %d, expected %d and %d", cover, d, c, depth, colorType)
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder().DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("decoded %q (%v) from a %T file, expected %q", decoded, err, cover, message)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncodeFileGIFAndJPEG(t *testing.T) {
+	var gifCover bytes.Buffer
+	if err := gif.Encode(&gifCover, newPalettedImage(40, 30, palette.WebSafe), nil); err != nil {
+		log.Printf("Error encoding GIF %v", err)
+		t.FailNow()
+	}
+	jpegData := jpegCover(texturedImage(64, 48), 90)
+	opts := Options{Key: []byte("key")}
+	message := []byte("format")
+
+	for _, test := range []struct {
+		cover          []byte
+		format, output string
+	}{
+		{gifCover.Bytes(), "", "gif"},
+		{jpegData, "", "jpeg"},
+		{jpegData, "jpeg", "jpeg"},
+		{jpegData, "JPG", "jpeg"},
+	} {
+		opts.Format = test.format
+		var out bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).EncodeFile(&out, bytes.NewReader(test.cover), message); err != nil {
+			log.Printf("Error writing a %s file as %q: %v", test.output, test.format, err)
+			t.FailNow()
+		}
+		if _, written, err := image.DecodeConfig(bytes.NewReader(out.Bytes())); err != nil || written != test.output {
+			log.Printf("written as %q (%v), expected %q", written, err, test.output)
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder(WithOptions(opts)).DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("decoded %q (%v) from a %s file, expected %q", decoded, err, test.output, message)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncodeFileOutputFormat(t *testing.T) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, texturedImage(64, 48)); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	message := []byte("output")
+
+	for format, expected := range map[string]error{
+		"jpeg": ErrLossyFormat,
+		"jpg":  ErrLossyFormat,
+		"WebP": ErrLossyFormat,
+		"gif":  ErrUnsupportedFormat, // a truecolor GIF would be quantized
+		"ico":  ErrUnsupportedFormat,
+	} {
+		if err := NewEncoder(WithFormat(format)).EncodeFile(new(bytes.Buffer), bytes.NewReader(cover.Bytes()), message); err != expected {
+			log.Printf("expected %v writing %s, got %v", expected, format, err)
+			t.FailNow()
+		}
+	}
+
+	// JPEG covers can be written losslessly as PNG, embedding in their pixels
+	jpegData := jpegCover(texturedImage(64, 48), 90)
+	opts := Options{Format: "PNG", Depth: 2}
+	var out bytes.Buffer
+	if err := NewEncoder(WithOptions(opts)).EncodeFile(&out, bytes.NewReader(jpegData), message); err != nil {
+		log.Printf("Error writing a JPEG cover as PNG: %v", err)
+		t.FailNow()
+	}
+	if _, written, err := image.DecodeConfig(bytes.NewReader(out.Bytes())); err != nil || written != "png" {
+		log.Printf("written as %q (%v), expected png", written, err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder(WithOptions(opts)).DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+}
+
+func TestPNGCompression(t *testing.T) {
+	cover := newTestImage(64, 64)
+	message := []byte("compression level")
+	sizes := make(map[png.CompressionLevel]int)
+	for _, level := range []png.CompressionLevel{png.NoCompression, png.BestCompression} {
+		var buf bytes.Buffer
+		if err := NewEncoder(WithPNGCompression(level)).Encode(&buf, cover, message); err != nil {
+			log.Printf("Error encoding message %v", err)
+			t.FailNow()
+		}
+		sizes[level] = buf.Len()
+		if decoded, err := NewDecoder().DecodeReader(&buf); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+			t.FailNow()
+		}
+	}
+	if sizes[png.BestCompression] >= sizes[png.NoCompression] {
+		log.Printf("best compression wrote %d bytes, no compression %d", sizes[png.BestCompression], sizes[png.NoCompression])
+		t.FailNow()
+	}
+}
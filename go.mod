@@ -1,3 +1,8 @@
 module github.com/auyer/steganography
 
-go 1.12
+go 1.17
+
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.12.0
+)
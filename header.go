@@ -0,0 +1,309 @@
+package steganography
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+// headerMagic identifies images carrying a payload written by this package.
+var headerMagic = [4]byte{'S', 'T', 'E', 'G'}
+
+const (
+	// headerVersion is the container format version written by Encode
+	headerVersion byte = 1
+	// headerSize is the number of bytes used by the fixed part of the container header
+	headerSize = 14
+	// legacyHeaderSize is the number of bytes used by the headerless format (a bare big-endian length)
+	legacyHeaderSize = 4
+)
+
+var (
+	// ErrInvalidHeader is returned when an image does not start with a valid container header
+	ErrInvalidHeader = errors.New("image does not carry a valid steganography header")
+	// ErrUnsupportedVersion is returned when the container header was written by a newer version of this package
+	ErrUnsupportedVersion = errors.New("unsupported steganography header version")
+	// ErrChecksumMismatch is returned when the decoded payload does not match the checksum recorded in the header
+	ErrChecksumMismatch = errors.New("payload checksum mismatch")
+)
+
+const (
+	// flagEncrypted marks payloads sealed with a passphrase, see EncodeEncrypted
+	flagEncrypted byte = 1 << iota
+	// flagDepth marks streams embedded in more than one low order bit per channel, see Options.Depth
+	flagDepth
+	// flagMatrix marks payloads embedded with a Hamming code, see Options.MatrixEmbedding
+	flagMatrix
+	// flagSTC marks payloads embedded with a syndrome-trellis code, see Options.Cost
+	flagSTC
+	// flagCompressed marks compressed payloads, see Options.Compress
+	flagCompressed
+	// flagFEC marks streams protected with Reed-Solomon codes, see Options.ErrorCorrection
+	flagFEC
+)
+
+// knownFlags is the set of flags understood by this version of the package
+const knownFlags = flagEncrypted | flagDepth | flagMatrix | flagSTC | flagCompressed | flagFEC
+
+// header is the self-describing container written in front of every payload.
+/*
+	Layout (big-endian):
+		magic    [4]byte : "STEG"
+		version  byte    : container format version
+		flags    byte    : features used by the payload
+		length   uint32  : payload length in bytes
+		checksum uint32  : CRC-32 (IEEE) of the payload, 0 for encrypted payloads which are authenticated instead
+	Followed by extensions, present only when the matching flag is set:
+		flagEncrypted  : logN byte, salt [16]byte, nonce [12]byte
+		flagDepth      : depth byte, number of low order bits used per channel (2 to 4)
+		flagMatrix     : k byte, Hamming code parameter of the payload (2 to 9), the header itself is not coded
+		flagSTC        : height byte, width byte, dimensions of the syndrome-trellis code submatrix, the header itself is not coded
+		flagCompressed : algorithm byte, 1 for DEFLATE, and the uint32 length of the decompressed message (sealed in front of encrypted payloads instead), the length and checksum describe the compressed payload
+		flagFEC        : parity byte, Reed-Solomon parity bytes per codeword (1 to 128), protecting the whole stream
+*/
+type header struct {
+	version  byte
+	flags    byte
+	length   uint32
+	checksum uint32
+
+	encryption   encryptionParams
+	depth        byte
+	matrix       byte
+	stc          stc
+	compression  byte
+	uncompressed uint32
+	parity       byte
+}
+
+// newHeader builds the header describing the given payload
+func newHeader(payload []byte) header {
+	return header{
+		version:  headerVersion,
+		length:   uint32(len(payload)),
+		checksum: crc32.ChecksumIEEE(payload),
+	}
+}
+
+// marshal returns the binary representation of the header
+func (h header) marshal() []byte {
+	b := make([]byte, 0, headerSize)
+	b = append(b, headerMagic[:]...)
+	b = append(b, h.version, h.flags)
+
+	one, two, three, four := splitToBytes(h.length)
+	b = append(b, one, two, three, four)
+
+	one, two, three, four = splitToBytes(h.checksum)
+	b = append(b, one, two, three, four)
+
+	if h.flags&flagEncrypted != 0 {
+		b = h.encryption.marshal(b)
+	}
+	if h.flags&flagDepth != 0 {
+		b = append(b, h.depth)
+	}
+	if h.flags&flagMatrix != 0 {
+		b = append(b, h.matrix)
+	}
+	if h.flags&flagSTC != 0 {
+		b = append(b, byte(h.stc.height), byte(h.stc.width))
+	}
+	if h.flags&flagCompressed != 0 {
+		b = append(b, h.compression)
+		if h.flags&flagEncrypted == 0 {
+			one, two, three, four = splitToBytes(h.uncompressed)
+			b = append(b, one, two, three, four)
+		}
+	}
+	if h.flags&flagFEC != 0 {
+		b = append(b, h.parity)
+	}
+	return b
+}
+
+// size returns the number of bytes used by the header, including its extensions
+func (h header) size() int {
+	size := headerSize
+	if h.flags&flagEncrypted != 0 {
+		size += encryptionParamsSize
+	}
+	if h.flags&flagDepth != 0 {
+		size++
+	}
+	if h.flags&flagMatrix != 0 {
+		size++
+	}
+	if h.flags&flagSTC != 0 {
+		size += 2
+	}
+	if h.flags&flagCompressed != 0 {
+		size += compressionParamsSize
+		if h.flags&flagEncrypted != 0 {
+			size -= 4 // the decompressed length is sealed with the payload
+		}
+	}
+	if h.flags&flagFEC != 0 {
+		size++
+	}
+	return size
+}
+
+// setDepth records the number of low order bits used per channel
+func (h *header) setDepth(depth int) {
+	h.flags &^= flagDepth
+	if depth > 1 {
+		h.flags |= flagDepth
+		h.depth = byte(depth)
+	}
+}
+
+// embeddingDepth returns the number of low order bits used per channel
+func (h header) embeddingDepth() int {
+	if h.flags&flagDepth == 0 {
+		return 1
+	}
+	return int(h.depth)
+}
+
+// setMatrix records the Hamming code parameter the payload is embedded with, 1 meaning one bit per sample
+func (h *header) setMatrix(k int) {
+	h.flags &^= flagMatrix
+	if k > 1 {
+		h.flags |= flagMatrix
+		h.matrix = byte(k)
+	}
+}
+
+// matrixParameter returns the Hamming code parameter the payload is embedded with, 1 meaning one bit per sample
+func (h header) matrixParameter() int {
+	if h.flags&flagMatrix == 0 {
+		return 1
+	}
+	return int(h.matrix)
+}
+
+// setSTC records the syndrome-trellis code the payload is embedded with
+func (h *header) setSTC(code stc) {
+	h.flags |= flagSTC
+	h.stc = code
+}
+
+// setCompression records the algorithm the payload is compressed with, and the length of the decompressed message
+func (h *header) setCompression(algorithm byte, length int) {
+	h.flags |= flagCompressed
+	h.compression = algorithm
+	h.uncompressed = uint32(length)
+}
+
+// setErrorCorrection records the number of Reed-Solomon parity bytes per codeword, 0 meaning no error correction
+func (h *header) setErrorCorrection(parity int) {
+	h.flags &^= flagFEC
+	if parity > 0 {
+		h.flags |= flagFEC
+		h.parity = byte(parity)
+	}
+}
+
+// errorCorrection returns the number of Reed-Solomon parity bytes per codeword, 0 meaning no error correction
+func (h header) errorCorrection() int {
+	if h.flags&flagFEC == 0 {
+		return 0
+	}
+	return int(h.parity)
+}
+
+// coded reports whether the payload is embedded with a code, instead of one bit per sample after the header
+func (h header) coded() bool {
+	return h.flags&(flagMatrix|flagSTC) != 0
+}
+
+// parseHeader validates and decodes the fixed part of a binary header
+// When h.size() is larger than headerSize, the extensions must be read with parseExtensions.
+func parseHeader(b []byte) (h header, err error) {
+	if len(b) < headerSize {
+		return h, ErrInvalidHeader
+	}
+	for i := range headerMagic {
+		if b[i] != headerMagic[i] {
+			return h, ErrInvalidHeader
+		}
+	}
+
+	h.version = b[4]
+	h.flags = b[5]
+	if h.version != headerVersion {
+		return h, ErrUnsupportedVersion
+	}
+	if h.flags&^knownFlags != 0 {
+		return h, ErrInvalidHeader
+	}
+
+	h.length = combineToInt(b[6], b[7], b[8], b[9])
+	h.checksum = combineToInt(b[10], b[11], b[12], b[13])
+	return h, nil
+}
+
+// parseExtensions decodes the header extensions from the complete binary header
+func (h *header) parseExtensions(b []byte) error {
+	if len(b) < h.size() {
+		return ErrInvalidHeader
+	}
+	b = b[headerSize:]
+
+	if h.flags&flagEncrypted != 0 {
+		if err := h.encryption.parse(b); err != nil {
+			return err
+		}
+		b = b[encryptionParamsSize:]
+	}
+	if h.flags&flagDepth != 0 {
+		if b[0] < 2 || b[0] > MaxDepth16 {
+			return ErrInvalidHeader
+		}
+		h.depth = b[0]
+		b = b[1:]
+	}
+	if h.flags&flagMatrix != 0 {
+		// matrix embedding only uses the least significant bit
+		if b[0] < 2 || b[0] > maxMatrixK || h.flags&flagDepth != 0 {
+			return ErrInvalidHeader
+		}
+		h.matrix = b[0]
+		b = b[1:]
+	}
+	if h.flags&flagSTC != 0 {
+		if b[0] < 2 || b[0] > maxSTCHeight || b[1] == 0 || h.flags&(flagDepth|flagMatrix) != 0 {
+			return ErrInvalidHeader
+		}
+		h.stc = stc{height: int(b[0]), width: int(b[1])}
+		b = b[2:]
+	}
+	if h.flags&flagCompressed != 0 {
+		if b[0] != compressionDeflate {
+			return ErrInvalidHeader
+		}
+		h.compression = b[0]
+		b = b[1:]
+		if h.flags&flagEncrypted == 0 {
+			if h.uncompressed = combineToInt(b[0], b[1], b[2], b[3]); h.uncompressed > maxDecompressedSize {
+				return ErrInvalidHeader
+			}
+			b = b[4:]
+		}
+	}
+	if h.flags&flagFEC != 0 {
+		if b[0] == 0 || b[0] > MaxErrorCorrection {
+			return ErrInvalidHeader
+		}
+		h.parity = b[0]
+	}
+	return nil
+}
+
+// verify checks the payload against the checksum recorded in the header
+func (h header) verify(payload []byte) error {
+	if crc32.ChecksumIEEE(payload) != h.checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package steganography
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestHeaderMarshalParse(t *testing.T) {
+	h := newHeader(bitmessage)
+
+	parsed, err := parseHeader(h.marshal())
+	if err != nil {
+		log.Printf("Error parsing header %v", err)
+		t.FailNow()
+	}
+	if parsed != h {
+		log.Printf("headers dont match: %+v %+v", parsed, h)
+		t.FailNow()
+	}
+
+	h.setDepth(3)
+	parsed, err = parseHeader(h.marshal())
+	if err == nil {
+		err = parsed.parseExtensions(h.marshal())
+	}
+	if err != nil || parsed != h || parsed.embeddingDepth() != 3 {
+		log.Printf("headers with depth dont match: %+v %+v %v", parsed, h, err)
+		t.FailNow()
+	}
+
+	b := h.marshal()
+	b[4] = headerVersion + 1
+	if _, err = parseHeader(b); err != ErrUnsupportedVersion {
+		log.Printf("Uncaught unsupported version: %v", err)
+		t.FailNow()
+	}
+
+	b = h.marshal()
+	b[0] = 0
+	if _, err = parseHeader(b); err != ErrInvalidHeader {
+		log.Printf("Uncaught invalid magic: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeAuto(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 50, 50))
+	for x := 0; x < 50; x++ {
+		for y := 0; y < 50; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 127, A: 255})
+		}
+	}
+
+	w := new(bytes.Buffer)
+	err := Encode(w, img, bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	msg, err := DecodeAuto(decodeImg)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	// flip the least significant bit of the red channel of a pixel holding the message
+	tampered := imageToNRGBA(decodeImg)
+	pixel := (headerSize + len(bitmessage)/2) * 8 / 3
+	c := tampered.NRGBAAt(pixel/50, pixel%50)
+	c.R ^= 1
+	tampered.SetNRGBA(pixel/50, pixel%50, c)
+
+	if _, err = DecodeAuto(tampered); err != ErrChecksumMismatch {
+		log.Printf("Uncaught corrupted message: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeAutoRefusesPlainImage(t *testing.T) {
+	inFile, err := os.Open(rawInputFilePng)
+	if err != nil {
+		log.Printf("Error opening file %s: %v", rawInputFilePng, err)
+		t.FailNow()
+	}
+	defer inFile.Close()
+
+	img, _, err := image.Decode(bufio.NewReader(inFile))
+	if err != nil {
+		log.Printf("Error decoding. %v", err)
+		t.FailNow()
+	}
+
+	if _, err = DecodeAuto(img); err != ErrInvalidHeader {
+		log.Printf("Uncaught image without payload: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeLegacyFromPngFile(t *testing.T) {
+	inFile, err := os.Open(legacyInputFilePng)
+	if err != nil {
+		log.Printf("Error opening file %s: %v", legacyInputFilePng, err)
+		t.FailNow()
+	}
+	defer inFile.Close()
+
+	img, _, err := image.Decode(bufio.NewReader(inFile))
+	if err != nil {
+		log.Print("Error decoding file")
+		t.FailNow()
+	}
+
+	msg := DecodeLegacy(GetLegacyMessageSizeFromImage(img), img)
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	// the legacy format is also detected by Decode
+	msg = Decode(GetMessageSizeFromImage(img), img)
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	if _, err = DecodeAuto(img); err != ErrInvalidHeader {
+		log.Printf("Uncaught legacy image without header: %v", err)
+		t.FailNow()
+	}
+}
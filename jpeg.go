@@ -0,0 +1,760 @@
+package steganography
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// JPEG images are lossy: their pixels are rebuilt from quantized DCT coefficients, so bits hidden in the pixels do not
+// survive JPEG encoding. Messages are hidden in the quantized coefficients instead, which are read from the entropy coded
+// data and written back without going through the pixels, so nothing is quantized twice. Only baseline images (sequential
+// DCT with Huffman coding) are supported. The Huffman tables are rebuilt for each scan when writing, as embedding
+// produces symbols the original tables may not define.
+
+var (
+	// ErrInvalidJPEG is returned when the JPEG data is malformed
+	ErrInvalidJPEG = errors.New("invalid JPEG data")
+	// ErrUnsupportedJPEG is returned for JPEG images that are not baseline (progressive, arithmetic coded, lossless or 12-bit),
+	// or too large to be held in memory (see maxJPEGBlocks)
+	ErrUnsupportedJPEG = errors.New("unsupported JPEG: only baseline Huffman coded images are supported")
+)
+
+// JPEG markers
+const (
+	markerSOF0 = 0xc0 // baseline DCT
+	markerSOF1 = 0xc1 // extended sequential DCT, Huffman coding
+	markerDHT  = 0xc4
+	markerRST0 = 0xd0
+	markerRST7 = 0xd7
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerDNL  = 0xdc
+	markerDRI  = 0xdd
+)
+
+// zigzagBlock is the number of coefficients of a block, which are stored in zigzag order, the DC coefficient first
+const zigzagBlock = 64
+
+// maxJPEGBlocks bounds the number of blocks of all the components of an image (128 MiB of coefficients, over 20
+// megapixels without chroma subsampling), as they are allocated from the frame header before any scan data is read
+const maxJPEGBlocks = 1 << 20
+
+// jpegImage holds the quantized DCT coefficients of a baseline JPEG image, and the segments needed to write it back
+type jpegImage struct {
+	width, height int
+	hmax, vmax    int // largest sampling factors
+	mcusX, mcusY  int // number of MCUs of interleaved scans
+	components    []jpegComponent
+	segments      []jpegSegment // segments in file order, Huffman tables excluded
+	scans         []jpegScan    // scans in file order, one per SOS segment
+}
+
+// jpegSegment is a marker segment kept verbatim, without its length
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegComponent holds the coefficients of one color component, on a grid of blocks padded to whole MCUs
+type jpegComponent struct {
+	id     byte
+	h, v   int     // sampling factors
+	stride int     // blocks per row of the padded grid
+	coefs  []int16 // coefficients of each block, row major
+}
+
+// jpegScan describes the components coded by a scan, and the Huffman tables they use
+type jpegScan struct {
+	components []int // indices in jpegImage.components
+	dc, ac     []int // table destinations of each component
+	restart    int   // restart interval in MCUs, 0 without restart markers
+}
+
+// blocks returns the number of blocks of the component covering image pixels, horizontally and vertically
+func (img *jpegImage) blocks(c int) (int, int) {
+	comp := img.components[c]
+	w := (img.width*comp.h + img.hmax - 1) / img.hmax
+	h := (img.height*comp.v + img.vmax - 1) / img.vmax
+	return (w + 7) / 8, (h + 7) / 8
+}
+
+// block returns the coefficients of the block at bx, by of the component
+func (img *jpegImage) block(c, bx, by int) []int16 {
+	comp := img.components[c]
+	i := (by*comp.stride + bx) * zigzagBlock
+	return comp.coefs[i : i+zigzagBlock]
+}
+
+// units returns the blocks of each MCU of the scan, calling fn with the MCU index and the blocks in coding order
+// A scan of a single component is not interleaved: its MCUs are single blocks, and padding blocks are not coded.
+func (img *jpegImage) units(scan jpegScan, fn func(mcu int, blocks []scanBlock) error) error {
+	if len(scan.components) == 1 {
+		c := scan.components[0]
+		w, h := img.blocks(c)
+		blocks := make([]scanBlock, 1)
+		for by := 0; by < h; by++ {
+			for bx := 0; bx < w; bx++ {
+				blocks[0] = scanBlock{0, img.block(c, bx, by)}
+				if err := fn(by*w+bx, blocks); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var blocks []scanBlock
+	for my := 0; my < img.mcusY; my++ {
+		for mx := 0; mx < img.mcusX; mx++ {
+			blocks = blocks[:0]
+			for i, c := range scan.components {
+				comp := img.components[c]
+				for y := 0; y < comp.v; y++ {
+					for x := 0; x < comp.h; x++ {
+						blocks = append(blocks, scanBlock{i, img.block(c, mx*comp.h+x, my*comp.v+y)})
+					}
+				}
+			}
+			if err := fn(my*img.mcusX+mx, blocks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanBlock is a block coded by a scan, with the position of its component in the scan
+type scanBlock struct {
+	component int
+	coefs     []int16
+}
+
+// readJPEG parses a baseline JPEG image, decoding the entropy coded data of its scans
+func readJPEG(r io.Reader) (*jpegImage, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, ErrInvalidJPEG
+	}
+
+	img := new(jpegImage)
+	var tables [2][4]*huffmanDecoder
+	restart := 0
+	pos := 2
+	for {
+		if pos >= len(data) || data[pos] != 0xff {
+			return nil, ErrInvalidJPEG
+		}
+		// markers may be preceded by any number of fill bytes
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, ErrInvalidJPEG
+		}
+		marker := data[pos]
+		pos++
+		if marker == markerEOI {
+			break
+		}
+		if pos+2 > len(data) {
+			return nil, ErrInvalidJPEG
+		}
+		length := int(data[pos])<<8 | int(data[pos+1])
+		if length < 2 || pos+length > len(data) {
+			return nil, ErrInvalidJPEG
+		}
+		segment := data[pos+2 : pos+length]
+		pos += length
+
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			if img.components != nil {
+				return nil, ErrInvalidJPEG
+			}
+			if err := img.parseFrame(segment); err != nil {
+				return nil, err
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != markerDHT, marker == markerDNL:
+			return nil, ErrUnsupportedJPEG
+		case marker == markerDHT:
+			if err := parseHuffmanTables(segment, &tables); err != nil {
+				return nil, err
+			}
+			continue // tables are rebuilt when writing
+		case marker == markerDRI:
+			if len(segment) != 2 {
+				return nil, ErrInvalidJPEG
+			}
+			restart = int(segment[0])<<8 | int(segment[1])
+		case marker == markerSOS:
+			if img.components == nil {
+				return nil, ErrInvalidJPEG
+			}
+			scan, err := img.parseScan(segment, restart)
+			if err != nil {
+				return nil, err
+			}
+			end := scanEnd(data, pos)
+			if err := img.decodeScan(scan, &tables, data[pos:end]); err != nil {
+				return nil, err
+			}
+			pos = end
+			img.scans = append(img.scans, scan)
+		case marker == markerSOI || (marker >= markerRST0 && marker <= markerRST7):
+			return nil, ErrInvalidJPEG
+		}
+		img.segments = append(img.segments, jpegSegment{marker, segment})
+	}
+	if len(img.scans) == 0 {
+		return nil, ErrInvalidJPEG
+	}
+	return img, nil
+}
+
+// parseFrame reads the frame header, and allocates the coefficients of the components
+func (img *jpegImage) parseFrame(segment []byte) error {
+	if len(segment) < 6 {
+		return ErrInvalidJPEG
+	}
+	if segment[0] != 8 {
+		return ErrUnsupportedJPEG
+	}
+	img.height = int(segment[1])<<8 | int(segment[2])
+	img.width = int(segment[3])<<8 | int(segment[4])
+	n := int(segment[5])
+	if img.width == 0 || img.height == 0 {
+		return ErrUnsupportedJPEG // the height would be defined by a DNL marker
+	}
+	if n == 0 || n > 4 || len(segment) != 6+3*n {
+		return ErrInvalidJPEG
+	}
+
+	img.hmax, img.vmax = 1, 1
+	for i := 0; i < n; i++ {
+		comp := jpegComponent{id: segment[6+3*i], h: int(segment[7+3*i] >> 4), v: int(segment[7+3*i] & 15)}
+		if comp.h < 1 || comp.h > 4 || comp.v < 1 || comp.v > 4 {
+			return ErrInvalidJPEG
+		}
+		if comp.h > img.hmax {
+			img.hmax = comp.h
+		}
+		if comp.v > img.vmax {
+			img.vmax = comp.v
+		}
+		img.components = append(img.components, comp)
+	}
+
+	if n == 1 {
+		// a single component is never interleaved, its sampling factors are irrelevant
+		img.components[0].h, img.components[0].v = 1, 1
+		img.hmax, img.vmax = 1, 1
+	}
+
+	img.mcusX = (img.width + 8*img.hmax - 1) / (8 * img.hmax)
+	img.mcusY = (img.height + 8*img.vmax - 1) / (8 * img.vmax)
+	blocks := 0
+	for _, comp := range img.components {
+		blocks += img.mcusX * comp.h * img.mcusY * comp.v
+	}
+	if blocks > maxJPEGBlocks {
+		return ErrUnsupportedJPEG
+	}
+	for i := range img.components {
+		comp := &img.components[i]
+		comp.stride = img.mcusX * comp.h
+		comp.coefs = make([]int16, comp.stride*img.mcusY*comp.v*zigzagBlock)
+	}
+	return nil
+}
+
+// parseScan reads the scan header
+func (img *jpegImage) parseScan(segment []byte, restart int) (jpegScan, error) {
+	scan := jpegScan{restart: restart}
+	if len(segment) < 1 {
+		return scan, ErrInvalidJPEG
+	}
+	n := int(segment[0])
+	if n == 0 || n > len(img.components) || len(segment) != 4+2*n {
+		return scan, ErrInvalidJPEG
+	}
+	for i := 0; i < n; i++ {
+		id, tables := segment[1+2*i], segment[2+2*i]
+		c := -1
+		for j, comp := range img.components {
+			if comp.id == id {
+				c = j
+			}
+		}
+		if c < 0 || tables>>4 > 3 || tables&15 > 3 {
+			return scan, ErrInvalidJPEG
+		}
+		scan.components = append(scan.components, c)
+		scan.dc = append(scan.dc, int(tables>>4))
+		scan.ac = append(scan.ac, int(tables&15))
+	}
+	if ss, se, a := segment[1+2*n], segment[2+2*n], segment[3+2*n]; ss != 0 || se != 63 || a != 0 {
+		return scan, ErrUnsupportedJPEG // spectral selection or successive approximation
+	}
+	return scan, nil
+}
+
+// scanEnd returns the position of the first marker after the entropy coded data starting at pos, restart markers excluded
+func scanEnd(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] != 0xff {
+			continue
+		}
+		if next := data[pos+1]; next != 0 && (next < markerRST0 || next > markerRST7) {
+			return pos
+		}
+		pos++
+	}
+	return len(data)
+}
+
+// decodeScan decodes the coefficients of the blocks of the scan from its entropy coded data
+func (img *jpegImage) decodeScan(scan jpegScan, tables *[2][4]*huffmanDecoder, data []byte) error {
+	r := &bitReader{data: data}
+	preds := make([]int, len(scan.components))
+	for i := range scan.components {
+		if tables[0][scan.dc[i]] == nil || tables[1][scan.ac[i]] == nil {
+			return ErrInvalidJPEG
+		}
+	}
+
+	return img.units(scan, func(mcu int, blocks []scanBlock) error {
+		if scan.restart > 0 && mcu > 0 && mcu%scan.restart == 0 {
+			if err := r.restart(mcu/scan.restart - 1); err != nil {
+				return err
+			}
+			for i := range preds {
+				preds[i] = 0
+			}
+		}
+		for _, b := range blocks {
+			s, err := tables[0][scan.dc[b.component]].decode(r)
+			if err != nil {
+				return err
+			}
+			diff, err := r.receive(int(s))
+			if err != nil {
+				return err
+			}
+			preds[b.component] += diff
+			b.coefs[0] = int16(preds[b.component])
+
+			ac := tables[1][scan.ac[b.component]]
+			for k := 1; k < zigzagBlock; k++ {
+				rs, err := ac.decode(r)
+				if err != nil {
+					return err
+				}
+				run, size := int(rs>>4), int(rs&15)
+				if size == 0 {
+					if run != 15 {
+						break // end of block
+					}
+					k += 15
+					continue
+				}
+				k += run
+				if k >= zigzagBlock {
+					return ErrInvalidJPEG
+				}
+				v, err := r.receive(size)
+				if err != nil {
+					return err
+				}
+				b.coefs[k] = int16(v)
+			}
+		}
+		return nil
+	})
+}
+
+// bitReader reads the entropy coded data of a scan, most significant bit first, removing the stuffed zero bytes
+type bitReader struct {
+	data []byte
+	pos  int
+	bits uint32
+	n    uint
+}
+
+func (r *bitReader) bit() (int, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) || (r.data[r.pos] == 0xff && (r.pos+1 >= len(r.data) || r.data[r.pos+1] != 0)) {
+			return 0, ErrInvalidJPEG // end of the data, or a restart marker before the end of the interval
+		}
+		r.bits = uint32(r.data[r.pos])
+		r.pos++
+		if r.bits == 0xff {
+			r.pos++ // stuffed zero byte
+		}
+		r.n = 8
+	}
+	r.n--
+	return int(r.bits>>r.n) & 1, nil
+}
+
+// receive reads a value of the given number of bits, and extends its sign (see F.2.2.1 of the JPEG standard)
+func (r *bitReader) receive(size int) (int, error) {
+	if size > 16 {
+		return 0, ErrInvalidJPEG
+	}
+	v := 0
+	for i := 0; i < size; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	if size > 0 && v < 1<<uint(size-1) {
+		v -= 1<<uint(size) - 1
+	}
+	return v, nil
+}
+
+// restart skips the padding bits and the nth restart marker
+func (r *bitReader) restart(n int) error {
+	r.n = 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xff || r.data[r.pos+1] != byte(markerRST0+n%8) {
+		return ErrInvalidJPEG
+	}
+	r.pos += 2
+	return nil
+}
+
+// huffmanDecoder decodes the symbols of a Huffman table, using the canonical code construction of Annex C
+type huffmanDecoder struct {
+	maxcode [17]int // largest code of each length, -1 when there is none
+	valptr  [17]int // index in values of the first code of each length, minus that code
+	values  []byte
+}
+
+// parseHuffmanTables reads the tables defined by a DHT segment
+func parseHuffmanTables(segment []byte, tables *[2][4]*huffmanDecoder) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 || segment[0]>>4 > 1 || segment[0]&15 > 3 {
+			return ErrInvalidJPEG
+		}
+		class, id := segment[0]>>4, segment[0]&15
+		d := new(huffmanDecoder)
+		total := 0
+		for l := 1; l <= 16; l++ {
+			total += int(segment[l])
+		}
+		if total > 256 || len(segment) < 17+total {
+			return ErrInvalidJPEG
+		}
+		d.values = segment[17 : 17+total]
+
+		code, k := 0, 0
+		for l := 1; l <= 16; l++ {
+			count := int(segment[l])
+			d.valptr[l] = k - code
+			d.maxcode[l] = -1
+			if count > 0 {
+				d.maxcode[l] = code + count - 1
+			}
+			code = (code + count) << 1
+			k += count
+		}
+		tables[class][id] = d
+		segment = segment[17+total:]
+	}
+	return nil
+}
+
+// decode reads the next symbol
+func (d *huffmanDecoder) decode(r *bitReader) (byte, error) {
+	code := 0
+	for l := 1; l <= 16; l++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if code <= d.maxcode[l] {
+			return d.values[d.valptr[l]+code], nil
+		}
+	}
+	return 0, ErrInvalidJPEG
+}
+
+// scanSink receives the symbols and bits of the entropy coded data of a scan
+type scanSink interface {
+	// symbol codes the symbol with the given table, 0 to 3 for DC tables and 4 to 7 for AC tables
+	symbol(table int, s byte)
+	// bits appends the n low order bits of v
+	bits(v int, n int)
+	// restart appends the nth restart marker
+	restart(n int)
+}
+
+// encodeScan codes the blocks of the scan into the sink
+func (img *jpegImage) encodeScan(scan jpegScan, sink scanSink) {
+	preds := make([]int, len(scan.components))
+	img.units(scan, func(mcu int, blocks []scanBlock) error {
+		if scan.restart > 0 && mcu > 0 && mcu%scan.restart == 0 {
+			sink.restart(mcu/scan.restart - 1)
+			for i := range preds {
+				preds[i] = 0
+			}
+		}
+		for _, b := range blocks {
+			diff := int(b.coefs[0]) - preds[b.component]
+			preds[b.component] = int(b.coefs[0])
+			size := bitSize(diff)
+			sink.symbol(scan.dc[b.component], byte(size))
+			sink.bits(magnitude(diff, size), size)
+
+			ac := 4 + scan.ac[b.component]
+			run := 0
+			for _, c := range b.coefs[1:] {
+				if c == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					sink.symbol(ac, 0xf0) // run of 16 zeros
+				}
+				size := bitSize(int(c))
+				sink.symbol(ac, byte(run<<4|size))
+				sink.bits(magnitude(int(c), size), size)
+				run = 0
+			}
+			if run > 0 {
+				sink.symbol(ac, 0) // end of block
+			}
+		}
+		return nil
+	})
+}
+
+// bitSize returns the number of bits of the magnitude of v
+func bitSize(v int) int {
+	v = abs(v)
+	size := 0
+	for ; v > 0; v >>= 1 {
+		size++
+	}
+	return size
+}
+
+// magnitude returns the bits coding v with the given size, negative values being coded as v-1
+func magnitude(v, size int) int {
+	if v < 0 {
+		v += 1<<uint(size) - 1
+	}
+	return v
+}
+
+// symbolCounter counts the symbols coded with each table, to build optimal Huffman tables
+type symbolCounter [8][256]int
+
+func (s *symbolCounter) symbol(table int, v byte) { s[table][v]++ }
+func (s *symbolCounter) bits(v, n int)            {}
+func (s *symbolCounter) restart(n int)            {}
+
+// huffmanEncoder holds the code of each symbol of a Huffman table
+type huffmanEncoder struct {
+	codes   [256]uint16
+	lengths [256]uint8
+}
+
+// huffmanTable returns the number of codes of each length from 1 to 16, and the symbols in code order,
+// of an optimal table for the symbol frequencies, following Annex K.2 of the JPEG standard
+func huffmanTable(freq [256]int) (counts [17]byte, values []byte) {
+	// a reserved symbol with the lowest frequency ensures that no code is made of ones only
+	var f [257]int
+	copy(f[:], freq[:])
+	f[256] = 1
+	var size [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		v1, v2 := -1, -1
+		for v := range f {
+			if f[v] == 0 {
+				continue
+			}
+			if v1 < 0 || f[v] <= f[v1] {
+				v1, v2 = v, v1
+			} else if v2 < 0 || f[v] <= f[v2] {
+				v2 = v
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+		f[v1] += f[v2]
+		f[v2] = 0
+		for size[v1]++; others[v1] >= 0; size[v1]++ {
+			v1 = others[v1]
+		}
+		others[v1] = v2
+		for size[v2]++; others[v2] >= 0; size[v2]++ {
+			v2 = others[v2]
+		}
+	}
+
+	var bits [258]int
+	longest := 0
+	for _, s := range size {
+		if s > 0 {
+			bits[s]++
+			if s > longest {
+				longest = s
+			}
+		}
+	}
+	// limit the code lengths to 16 bits, moving pairs of long codes under shorter ones
+	for i := longest; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// remove the reserved symbol, which has one of the longest codes
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+	for l := 1; l <= 16; l++ {
+		counts[l] = byte(bits[l])
+	}
+
+	for s := 1; s <= longest; s++ {
+		for v := 0; v < 256; v++ {
+			if size[v] == s {
+				values = append(values, byte(v))
+			}
+		}
+	}
+	return counts, values
+}
+
+// newHuffmanEncoder returns the codes of the canonical Huffman table
+func newHuffmanEncoder(counts [17]byte, values []byte) *huffmanEncoder {
+	e := new(huffmanEncoder)
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(counts[l]); i++ {
+			e.codes[values[k]] = uint16(code)
+			e.lengths[values[k]] = uint8(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return e
+}
+
+// bitWriter writes the entropy coded data of a scan, stuffing a zero byte after each 0xff byte
+type bitWriter struct {
+	w      *bufio.Writer
+	tables [8]*huffmanEncoder
+	acc    uint32
+	n      uint
+}
+
+func (w *bitWriter) symbol(table int, s byte) {
+	e := w.tables[table]
+	w.bits(int(e.codes[s]), int(e.lengths[s]))
+}
+
+func (w *bitWriter) bits(v, n int) {
+	w.acc = w.acc<<uint(n) | uint32(v)&(1<<uint(n)-1)
+	w.n += uint(n)
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.w.WriteByte(b)
+		if b == 0xff {
+			w.w.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with ones
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.bits(1<<(8-w.n)-1, int(8-w.n))
+	}
+	w.acc = 0
+}
+
+func (w *bitWriter) restart(n int) {
+	w.flush()
+	w.w.Write([]byte{0xff, byte(markerRST0 + n%8)})
+}
+
+// write encodes the image as JPEG, with the coefficients it holds
+func (img *jpegImage) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write([]byte{0xff, markerSOI})
+	scan := 0
+	for _, segment := range img.segments {
+		if segment.marker == markerSOS {
+			img.writeScan(bw, img.scans[scan], segment)
+			scan++
+			continue
+		}
+		writeSegment(bw, segment)
+	}
+	bw.Write([]byte{0xff, markerEOI})
+	return bw.Flush()
+}
+
+// writeScan writes the optimal Huffman tables of the scan, its header and its entropy coded data
+func (img *jpegImage) writeScan(w *bufio.Writer, scan jpegScan, header jpegSegment) {
+	var counter symbolCounter
+	img.encodeScan(scan, &counter)
+
+	used := make(map[int]bool)
+	for i := range scan.components {
+		used[scan.dc[i]] = true
+		used[4+scan.ac[i]] = true
+	}
+	sink := &bitWriter{w: w}
+	var dht []byte
+	for table := 0; table < 8; table++ {
+		if !used[table] {
+			continue
+		}
+		counts, values := huffmanTable(counter[table])
+		sink.tables[table] = newHuffmanEncoder(counts, values)
+		dht = append(dht, byte(table/4<<4|table%4))
+		dht = append(dht, counts[1:]...)
+		dht = append(dht, values...)
+	}
+	writeSegment(w, jpegSegment{markerDHT, dht})
+	writeSegment(w, header)
+
+	img.encodeScan(scan, sink)
+	sink.flush()
+}
+
+// writeSegment writes the marker and the length of the segment, followed by its data
+func writeSegment(w *bufio.Writer, segment jpegSegment) {
+	length := len(segment.data) + 2
+	w.Write([]byte{0xff, segment.marker, byte(length >> 8), byte(length)})
+	w.Write(segment.data)
+}
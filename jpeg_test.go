@@ -0,0 +1,239 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+// texturedImage returns a gradient with pseudo-random noise, whose JPEG encoding has many non zero coefficients
+func texturedImage(width, height int) *image.NRGBA {
+	img := newTestImage(width, height)
+	rng := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		if i%4 != 3 {
+			img.Pix[i] += byte(rng.Intn(64))
+		}
+	}
+	return img
+}
+
+// jpegCover returns the JPEG encoding of a generated image
+// Encoding to memory does not fail, and broken data would be refused by readJPEG anyway.
+func jpegCover(img image.Image, quality int) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	return buf.Bytes()
+}
+
+// samePixels reports whether both images have the same size and pixels, wherever their bounds start
+func samePixels(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			ca := color.NRGBA64Model.Convert(a.At(a.Bounds().Min.X+x, a.Bounds().Min.Y+y))
+			cb := color.NRGBA64Model.Convert(b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y))
+			if ca != cb {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestJPEGRoundTrip(t *testing.T) {
+	stegosaurus, err := ioutil.ReadFile("./examples/stegosaurus.jpg") // subsampled chroma and restart markers
+	if err != nil {
+		log.Printf("Error reading file %v", err)
+		t.FailNow()
+	}
+	gray := image.NewGray(image.Rect(0, 0, 37, 21))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * 7)
+	}
+
+	for _, data := range [][]byte{stegosaurus, jpegCover(texturedImage(77, 45), 90), jpegCover(gray, 75)} {
+		pixels, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Error decoding JPEG %v", err)
+			t.FailNow()
+		}
+		// the first rewrite rebuilds the Huffman tables, rewriting the result again must not change it
+		var rewritten []byte
+		for i := 0; i < 2; i++ {
+			img, err := readJPEG(bytes.NewReader(data))
+			if err != nil {
+				log.Printf("Error reading JPEG %v", err)
+				t.FailNow()
+			}
+			var buf bytes.Buffer
+			if err := img.write(&buf); err != nil {
+				log.Printf("Error writing JPEG %v", err)
+				t.FailNow()
+			}
+			if i == 1 && !bytes.Equal(buf.Bytes(), rewritten) {
+				log.Print("rewriting a rewritten JPEG changed it")
+				t.FailNow()
+			}
+			decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil || !samePixels(pixels, decoded) {
+				log.Printf("rewritten JPEG decodes to other pixels (%v)", err)
+				t.FailNow()
+			}
+			data, rewritten = buf.Bytes(), buf.Bytes()
+		}
+	}
+}
+
+func TestJPEGSeparateScans(t *testing.T) {
+	data := jpegCover(texturedImage(50, 30), 85)
+	img, err := readJPEG(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error reading JPEG %v", err)
+		t.FailNow()
+	}
+
+	// code each component in its own scan, which is not interleaved
+	var segments []jpegSegment
+	var scans []jpegScan
+	for _, segment := range img.segments {
+		if segment.marker != markerSOS {
+			segments = append(segments, segment)
+			continue
+		}
+		for i, c := range img.scans[0].components {
+			tables := byte(img.scans[0].dc[i]<<4 | img.scans[0].ac[i])
+			segments = append(segments, jpegSegment{markerSOS, []byte{1, img.components[c].id, tables, 0, 63, 0}})
+			scans = append(scans, jpegScan{[]int{c}, img.scans[0].dc[i : i+1], img.scans[0].ac[i : i+1], 0})
+		}
+	}
+	img.segments, img.scans = segments, scans
+
+	var buf bytes.Buffer
+	if err := img.write(&buf); err != nil {
+		log.Printf("Error writing JPEG %v", err)
+		t.FailNow()
+	}
+	pixels, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error decoding JPEG %v", err)
+		t.FailNow()
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil || !samePixels(pixels, decoded) {
+		log.Printf("rewritten JPEG decodes to other pixels (%v)", err)
+		t.FailNow()
+	}
+	img, err = readJPEG(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		log.Printf("Error reading the rewritten JPEG %v", err)
+		t.FailNow()
+	}
+	var again bytes.Buffer
+	if err := img.write(&again); err != nil || !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		log.Printf("rewriting a rewritten JPEG changed it (%v)", err)
+		t.FailNow()
+	}
+}
+
+func TestJPEGRestartInterval(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 45, 29))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * i)
+	}
+	data := jpegCover(gray, 80)
+	img, err := readJPEG(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error reading JPEG %v", err)
+		t.FailNow()
+	}
+
+	// add a restart marker every 4 blocks
+	var segments []jpegSegment
+	for _, segment := range img.segments {
+		if segment.marker == markerSOS {
+			segments = append(segments, jpegSegment{markerDRI, []byte{0, 4}})
+		}
+		segments = append(segments, segment)
+	}
+	img.segments = segments
+	img.scans[0].restart = 4
+
+	var buf bytes.Buffer
+	if err := img.write(&buf); err != nil {
+		log.Printf("Error writing JPEG %v", err)
+		t.FailNow()
+	}
+	if bytes.Count(buf.Bytes(), []byte{0xff, markerRST0 + 3}) == 0 {
+		log.Print("no restart marker written")
+		t.FailNow()
+	}
+	pixels, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error decoding JPEG %v", err)
+		t.FailNow()
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil || !samePixels(pixels, decoded) {
+		log.Printf("rewritten JPEG decodes to other pixels (%v)", err)
+		t.FailNow()
+	}
+	img, err = readJPEG(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		log.Printf("Error reading the rewritten JPEG %v", err)
+		t.FailNow()
+	}
+	var again bytes.Buffer
+	if err := img.write(&again); err != nil || !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		log.Printf("rewriting a rewritten JPEG changed it (%v)", err)
+		t.FailNow()
+	}
+}
+
+func TestJPEGUnsupported(t *testing.T) {
+	if _, err := readJPEG(bytes.NewReader([]byte("not a JPEG"))); err != ErrInvalidJPEG {
+		log.Printf("expected ErrInvalidJPEG, got %v", err)
+		t.FailNow()
+	}
+
+	data := jpegCover(texturedImage(16, 16), 90)
+	progressive := append([]byte{}, data...)
+	for i := 2; i+1 < len(progressive); i++ {
+		if progressive[i] == 0xff && progressive[i+1] == markerSOF0 {
+			progressive[i+1] = 0xc2
+			break
+		}
+	}
+	if _, err := readJPEG(bytes.NewReader(progressive)); err != ErrUnsupportedJPEG {
+		log.Printf("expected ErrUnsupportedJPEG, got %v", err)
+		t.FailNow()
+	}
+
+	// a frame header of 65535 by 65535 pixels would allocate gigabytes of coefficients
+	huge := append([]byte{}, data...)
+	for i := 2; i+8 < len(huge); i++ {
+		if huge[i] == 0xff && huge[i+1] == markerSOF0 {
+			copy(huge[i+5:], []byte{0xff, 0xff, 0xff, 0xff})
+			break
+		}
+	}
+	if _, err := readJPEG(bytes.NewReader(huge)); err != ErrUnsupportedJPEG {
+		log.Printf("expected ErrUnsupportedJPEG for a huge frame, got %v", err)
+		t.FailNow()
+	}
+	if _, err := NewDecoder().DecodeJPEG(bytes.NewReader(huge)); err != ErrUnsupportedJPEG {
+		log.Printf("expected ErrUnsupportedJPEG decoding a huge frame, got %v", err)
+		t.FailNow()
+	}
+	if _, err := readJPEG(bytes.NewReader(data[:len(data)/2])); err == nil {
+		log.Print("expected an error on truncated data")
+		t.FailNow()
+	}
+}
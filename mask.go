@@ -0,0 +1,59 @@
+package steganography
+
+import "image"
+
+// maskThreshold is the alpha value from which a pixel of Options.Mask selects the cover pixel, half of the 16 bit range
+const maskThreshold = 0x8000
+
+// masked reports whether the options restrict the pixels carrying the message to a region of interest
+func (opts Options) masked() bool {
+	return opts.Mask != nil || len(opts.Include) > 0 || len(opts.Exclude) > 0
+}
+
+// region returns, for each pixel of an image of the given size in row major order, whether it may carry bits
+// It returns nil when every pixel may carry bits.
+func (opts Options) region(width, height int) []bool {
+	if !opts.masked() {
+		return nil
+	}
+
+	region := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			region[y*width+x] = opts.selects(image.Point{x, y})
+		}
+	}
+	return region
+}
+
+// selects reports whether the pixel may carry bits, according to the mask and the rectangles
+func (opts Options) selects(p image.Point) bool {
+	if opts.Mask != nil {
+		if _, _, _, a := opts.Mask.At(p.X, p.Y).RGBA(); a < maskThreshold {
+			return false
+		}
+	}
+	if len(opts.Include) > 0 && !inRectangles(p, opts.Include) {
+		return false
+	}
+	return !inRectangles(p, opts.Exclude)
+}
+
+// inRectangles reports whether the point is in any of the rectangles
+func inRectangles(p image.Point, rectangles []image.Rectangle) bool {
+	for _, r := range rectangles {
+		if p.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRegion reports whether the pixel at x, y of the carrier image may carry bits
+func (c slotCarrier) inRegion(x, y int) bool {
+	if c.region == nil {
+		return true
+	}
+	bounds := c.rgbImage.Bounds()
+	return c.region[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X]
+}
@@ -0,0 +1,92 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"log"
+	"testing"
+)
+
+// changedPixels returns the pixels of the stego image that differ from the cover
+func changedPixels(cover, stego *image.NRGBA) []image.Point {
+	var changed []image.Point
+	for x := 0; x < cover.Bounds().Dx(); x++ {
+		for y := 0; y < cover.Bounds().Dy(); y++ {
+			if cover.NRGBAAt(x, y) != stego.NRGBAAt(x, y) {
+				changed = append(changed, image.Point{x, y})
+			}
+		}
+	}
+	return changed
+}
+
+func TestEncodeDecodeWithRegion(t *testing.T) {
+	cover := newTestImage(80, 80)
+	logo := image.Rect(0, 0, 40, 40)
+	caption := image.Rect(0, 70, 80, 80)
+
+	// the mask only selects the right half of the image
+	mask := image.NewAlpha(image.Rect(0, 0, 80, 80))
+	for x := 40; x < 80; x++ {
+		for y := 0; y < 80; y++ {
+			mask.SetAlpha(x, y, color.Alpha{A: 255})
+		}
+	}
+
+	for _, test := range []struct {
+		opts    Options
+		allowed func(p image.Point) bool
+	}{
+		{Options{Exclude: []image.Rectangle{logo, caption}}, func(p image.Point) bool { return !p.In(logo) && !p.In(caption) }},
+		{Options{Include: []image.Rectangle{image.Rect(20, 20, 60, 60)}, Key: []byte("secret key")}, func(p image.Point) bool { return p.In(image.Rect(20, 20, 60, 60)) }},
+		{Options{Mask: mask, Exclude: []image.Rectangle{caption}, Depth: 2}, func(p image.Point) bool { return p.X >= 40 && !p.In(caption) }},
+	} {
+		stego, err := EmbedImage(cover, bitmessage, test.opts)
+		if err != nil {
+			log.Printf("Error embedding message %v", err)
+			t.FailNow()
+		}
+		for _, p := range changedPixels(cover, stego.(*image.NRGBA)) {
+			if !test.allowed(p) {
+				log.Printf("pixel %v outside of the region was modified", p)
+				t.FailNow()
+			}
+		}
+
+		msg, err := NewDecoder(WithOptions(test.opts)).Decode(stego)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+	}
+}
+
+func TestMaxEncodeSizeWithRegion(t *testing.T) {
+	cover := newTestImage(80, 80)
+	opts := Options{Include: []image.Rectangle{image.Rect(0, 0, 40, 80)}}
+	size := NewEncoder(WithOptions(opts)).MaxEncodeSize(cover)
+	if size != messageCapacity(40*80*3/8) {
+		log.Printf("capacity %d does not match the region", size)
+		t.FailNow()
+	}
+
+	if _, err := EmbedImage(cover, make([]byte, size), opts); err != nil {
+		log.Printf("Error embedding message filling the region %v", err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(cover, make([]byte, size+1), opts); err != ErrMessageTooLarge {
+		log.Printf("Uncaught message exceeding the region: %v", err)
+		t.FailNow()
+	}
+
+	size = NewEncoder(WithExclude(image.Rect(0, 0, 80, 80))).MaxEncodeSize(cover)
+	if size != 0 {
+		log.Printf("capacity %d of an excluded image", size)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,107 @@
+package steganography
+
+// Matrix embedding (as in F5) hides k message bits in the least significant bits of a group of 2^k-1 samples,
+// changing at most one of them. The k bits are the syndrome of the group for the (1, 2^k-1, k) Hamming code:
+// the xor of the 1-based indices of the samples whose least significant bit is set. Flipping the sample whose
+// index is the xor of the current syndrome and the message bits yields any message.
+// Larger codes change fewer samples per message bit, but need more samples, so the largest k fitting
+// the payload in the image is used, and recorded in the header.
+
+// maxMatrixK is the largest Hamming code parameter used for matrix embedding
+const maxMatrixK = 9
+
+// matrixParameter returns the largest Hamming code parameter k embedding bits message bits in the given number of samples
+// It returns 1 when no code fits, in which case the message is embedded one bit per sample.
+func matrixParameter(samples, bits int) int {
+	for k := maxMatrixK; k > 1; k-- {
+		if matrixSamples(bits, k) <= samples {
+			return k
+		}
+	}
+	return 1
+}
+
+// matrixSamples returns the number of samples used to embed bits message bits with the Hamming code parameter k
+func matrixSamples(bits, k int) int {
+	return (bits + k - 1) / k * (1<<uint(k) - 1)
+}
+
+// matrixGroup returns the next k message bits, starting at bit i, padded with zeros past the end of the payload
+func matrixGroup(payload []byte, i, k int) int {
+	var m int
+	for b := i; b < i+k; b++ {
+		m <<= 1
+		if b < len(payload)*8 {
+			m |= int(getBitFromByte(payload[b/8], b%8))
+		}
+	}
+	return m
+}
+
+// syndrome returns the Hamming syndrome of the least significant bits of the samples at the given Pix positions
+func syndrome(pix []byte, group []int) int {
+	var s int
+	for j, offset := range group {
+		if pix[offset]&1 != 0 {
+			s ^= j + 1
+		}
+	}
+	return s
+}
+
+func (c slotCarrier) writeMatrix(head, payload []byte, k int) {
+	rng := c.matchingSource()
+	next := c.samples()
+	for i := 0; i < len(head)*8; i++ {
+		c.store(next(), getBitFromByte(head[i/8], i%8), 1, 0, rng)
+	}
+
+	group := make([]int, 1<<uint(k)-1)
+	for i := 0; i < len(payload)*8; i += k {
+		for j := range group {
+			group[j] = next()
+		}
+		if s := syndrome(c.rgbImage.Pix, group) ^ matrixGroup(payload, i, k); s != 0 {
+			offset := group[s-1]
+			c.store(offset, c.rgbImage.Pix[offset]&1^1, 1, 0, rng)
+		}
+	}
+}
+
+func (c slotCarrier) readMatrix(offset, length uint32, k int) []byte {
+	next := c.samples()
+	for i := 0; i < int(offset)*8; i++ {
+		next()
+	}
+
+	message := make([]byte, length)
+	bits := len(message) * 8
+	group := make([]int, 1<<uint(k)-1)
+	for i := 0; i < bits; i += k {
+		for j := range group {
+			group[j] = next()
+			if group[j] < 0 {
+				return message
+			}
+		}
+		s := syndrome(c.rgbImage.Pix, group)
+		for b := 0; b < k && i+b < bits; b++ {
+			j := i + b
+			message[j/8] = setBitInByte(message[j/8], uint32(j%8), byte(s>>uint(k-1-b))&1)
+		}
+	}
+	return message
+}
+
+// slots returns the slot carrier visiting the same samples as the sequential carrier
+func (c sequentialCarrier) slots() slotCarrier {
+	return newSlotCarrier(c.rgbImage, nil, 1, RGB)
+}
+
+func (c sequentialCarrier) writeMatrix(head, payload []byte, k int) {
+	c.slots().writeMatrix(head, payload, k)
+}
+
+func (c sequentialCarrier) readMatrix(offset, length uint32, k int) []byte {
+	return c.slots().readMatrix(offset, length, k)
+}
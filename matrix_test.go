@@ -0,0 +1,115 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"testing"
+)
+
+func TestMatrixParameter(t *testing.T) {
+	for _, test := range []struct{ samples, bits, k int }{
+		{samples: 7, bits: 3, k: 3},
+		{samples: 6, bits: 3, k: 2},
+		{samples: 2, bits: 3, k: 1},
+		{samples: 1 << 20, bits: 8, k: maxMatrixK},
+		{samples: 800, bits: 100, k: 5}, // 20 groups of 31 samples, 6 bits would need 17 groups of 63
+	} {
+		if k := matrixParameter(test.samples, test.bits); k != test.k {
+			log.Printf("matrixParameter(%d, %d) = %d, expected %d", test.samples, test.bits, k, test.k)
+			t.FailNow()
+		}
+	}
+}
+
+func TestMatrixEmbedding(t *testing.T) {
+	cover := newTestImage(100, 100)
+	for _, opts := range []Options{
+		{MatrixEmbedding: true},
+		{MatrixEmbedding: true, Key: []byte("secret key"), Channels: RGBA},
+		{MatrixEmbedding: true, LSBMatching: true},
+		{MatrixEmbedding: true, Passphrase: []byte("passphrase")},
+	} {
+		stego, err := EmbedImage(cover, bitmessage, opts)
+		if err != nil {
+			log.Printf("Error embedding message %v", err)
+			t.FailNow()
+		}
+		plain, _ := EmbedImage(cover, bitmessage, Options{Key: opts.Key, Channels: opts.Channels, Passphrase: opts.Passphrase})
+
+		h, err := readHeader(opts.carrier(stego.(*image.NRGBA), 1))
+		if err != nil || h.matrixParameter() < 2 {
+			log.Printf("matrix parameter not recorded: %v %d", err, h.matrixParameter())
+			t.FailNow()
+		}
+
+		// at most one change per group of samples, far fewer than one change every other bit
+		groups := (int(h.length)*8 + h.matrixParameter() - 1) / h.matrixParameter()
+		changes, plainChanges := 0, 0
+		for i, v := range stego.(*image.NRGBA).Pix {
+			if v != cover.Pix[i] {
+				changes++
+			}
+			if plain.(*image.NRGBA).Pix[i] != cover.Pix[i] {
+				plainChanges++
+			}
+		}
+		if changes > groups+h.size()*8 || changes >= plainChanges {
+			log.Printf("matrix embedding changed %d samples for %d groups, against %d", changes, groups, plainChanges)
+			t.FailNow()
+		}
+
+		msg, err := NewDecoder(WithOptions(Options{Key: opts.Key, Channels: opts.Channels, Passphrase: opts.Passphrase})).Decode(stego)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+	}
+}
+
+func TestMatrixEmbeddingDecode(t *testing.T) {
+	stego, err := EmbedImage(newTestImage(100, 100), bitmessage, Options{MatrixEmbedding: true})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+
+	size := GetMessageSizeFromImage(stego)
+	if !bytes.Equal(Decode(size, stego), bitmessage) {
+		log.Print("Decode does not read matrix embedded messages")
+		t.FailNow()
+	}
+	if msg, err := DecodeE(size, stego); err != nil || !bytes.Equal(msg, bitmessage) {
+		log.Printf("DecodeE does not read matrix embedded messages: %v", err)
+		t.FailNow()
+	}
+	if _, err := DecodeE(1<<20, stego); err != ErrLengthExceedsCapacity {
+		log.Printf("Uncaught length exceeding capacity: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestMatrixEmbeddingFallback(t *testing.T) {
+	// a message filling the image can only be embedded one bit per sample
+	cover := newTestImage(48, 48)
+	message := make([]byte, MaxEncodeSize(cover))
+	stego, err := EmbedImage(cover, message, Options{MatrixEmbedding: true})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	msg, err := DecodeAuto(stego)
+	if err != nil || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+
+	if _, err = EmbedImage(cover, bitmessage, Options{MatrixEmbedding: true, Depth: 2}); err != ErrMatrixDepth {
+		log.Printf("Uncaught matrix embedding depth: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,278 @@
+package steganography
+
+import (
+	"errors"
+	"image"
+	"image/png"
+)
+
+// MaxDepth is the maximum number of low order bits that can be used in each channel
+const MaxDepth = 4
+
+var (
+	// ErrInvalidDepth is returned when Options.Depth is not between 1 and MaxDepth, or MaxDepth16 for 16-bit images
+	ErrInvalidDepth = errors.New("depth must be between 1 and 4 bits per channel, or 8 for 16-bit images")
+	// ErrInvalidChannels is returned when Options.Channels selects unknown channels
+	ErrInvalidChannels = errors.New("channels must be a combination of Red, Green, Blue and Alpha")
+	// ErrMatrixDepth is returned when Options.MatrixEmbedding is combined with a depth larger than 1
+	ErrMatrixDepth = errors.New("matrix embedding requires a depth of 1")
+	// ErrCostDepth is returned when Options.Cost is combined with a depth larger than 1
+	ErrCostDepth = errors.New("cost based embedding requires a depth of 1")
+	// ErrMatrixCost is returned when Options.MatrixEmbedding and Options.Cost are both set
+	ErrMatrixCost = errors.New("matrix embedding can not be combined with cost based embedding")
+	// ErrInvalidTextureThreshold is returned when Options.TextureThreshold is negative
+	ErrInvalidTextureThreshold = errors.New("texture threshold must not be negative")
+	// ErrAdaptiveMatching is returned when Options.TextureThreshold is combined with LSB matching,
+	// which may change the bits the texture is computed on
+	ErrAdaptiveMatching = errors.New("adaptive embedding can not be combined with LSB matching")
+)
+
+// Channels selects the color channels of the image carrying the message
+type Channels uint8
+
+const (
+	// Red selects the red channel
+	Red Channels = 1 << iota
+	// Green selects the green channel
+	Green
+	// Blue selects the blue channel
+	Blue
+	// Alpha selects the alpha channel. Transparent pixels are skipped when it is selected.
+	Alpha
+
+	// RGB selects the red, green and blue channels, the default
+	RGB = Red | Green | Blue
+	// RGBA selects all channels
+	RGBA = RGB | Alpha
+)
+
+// offsets returns the positions of the selected channels in an NRGBA pixel, in R, G, B, A order
+func (ch Channels) offsets() []int {
+	var offsets []int
+	for i := uint(0); i < 4; i++ {
+		if ch&(1<<i) != 0 {
+			offsets = append(offsets, int(i))
+		}
+	}
+	return offsets
+}
+
+// Options configures how a message is embedded in an image. The zero value matches Encode and DecodeAuto.
+// The same options must be given to the Decoder to read the message back, see WithOptions.
+type Options struct {
+	// Key seeds a pseudo-random traversal of the (pixel, channel) slots of the image, scattering the message over the whole picture.
+	// The message can not be extracted without the key. When empty, pixels are walked column by column from the top left corner.
+	Key []byte
+
+	// Passphrase, when not empty, encrypts the message as EncodeEncrypted does.
+	Passphrase []byte
+
+	// Depth is the number of low order bits used in each channel, from 1 (the default) to MaxDepth, or MaxDepth16 for 16-bit images.
+	// Deeper embedding multiplies the capacity, at the cost of more visible changes.
+	// It is recorded in the header, so decoders discover it on their own.
+	Depth int
+
+	// Channels selects the channels carrying the message, RGB when zero.
+	// When Alpha is selected, pixels whose alpha is below 2^Depth are left untouched, so the image stays lossless when written as PNG.
+	Channels Channels
+
+	// LSBMatching, when true, randomly increments or decrements the samples whose low order bits must change,
+	// instead of overwriting their bits. This avoids the pairs of values artifact of LSB replacement exploited by
+	// chi-square attacks. Decoding is unchanged, as only the low order bits are read.
+	LSBMatching bool
+
+	// MatrixEmbedding, when true, embeds k message bits in 2^k-1 least significant bits changing at most one of them,
+	// using a Hamming code. The largest k fitting the message in the image is used, and recorded in the header,
+	// so short messages in large images change far fewer samples. It requires a Depth of 1.
+	MatrixEmbedding bool
+
+	// Cost, when not nil, embeds the message with a syndrome-trellis code minimizing the total cost of the changed samples,
+	// as given by the cost function evaluated on the cover (see UniformCost and TextureCost). It requires a Depth of 1,
+	// and is not needed when decoding.
+	Cost CostFunction
+
+	// TextureThreshold, when positive, only embeds in pixels whose texture is at least the threshold, leaving flat regions
+	// such as skies or solid backgrounds untouched. The texture is the sum of the absolute differences between the red, green
+	// and blue values of a pixel and its 8 neighbours, computed on the bits above Depth which embedding never changes.
+	// The same threshold must be given when decoding. It can not be combined with LSBMatching.
+	TextureThreshold int
+
+	// Mask, when not nil, restricts the message to the pixels where the mask is opaque (alpha of at least half),
+	// following the convention of image/draw. Pixels outside the bounds of the mask are excluded.
+	Mask image.Image
+
+	// Include, when not empty, restricts the message to the pixels inside one of the rectangles.
+	Include []image.Rectangle
+
+	// Exclude leaves the pixels inside any of the rectangles untouched, to keep logos, faces or captions pristine.
+	// The mask and the rectangles use coordinates relative to the top left corner of the image, and must be given when decoding.
+	Exclude []image.Rectangle
+
+	// Compress, when true, compresses the message with DEFLATE before embedding (and encrypting) it, unless this does not
+	// make it smaller. The algorithm is recorded in the header, and the message decompressed when decoding.
+	// Compressed messages are stored with their compressed length, see Encoder.Fits to check whether they fit.
+	Compress bool
+
+	// ErrorCorrection, when positive, protects the header and the message with Reed-Solomon codes holding that many parity bytes
+	// per codeword of 255 bytes, up to MaxErrorCorrection. Each codeword survives up to half as many corrupted bytes, caused
+	// by minor edits, color conversions or buggy encoders, see Decoder.DecodeCorrected. The same value must be given when decoding.
+	// It can not be combined with MatrixEmbedding or Cost.
+	ErrorCorrection int
+
+	// Format is the name of the image format Encoder.EncodeFile writes, as registered with the image package ("png", "bmp", "tiff"...).
+	// When empty, the format of the cover is kept. Lossy formats are refused with ErrLossyFormat. Decoders ignore it.
+	Format string
+
+	// PNGCompression is the compression level of the PNG files written by the Encoder, png.DefaultCompression when zero.
+	PNGCompression png.CompressionLevel
+
+	// TIFFCompression is the compression of the TIFF files written by the Encoder, TIFFUncompressed when zero.
+	TIFFCompression TIFFCompression
+
+	// PNGChunk is the type of the chunk Encoder.EncodePNGChunk stores the message in: a non-standard ancillary chunk type,
+	// DefaultPNGChunk when empty, or zTXt or iTXt to store it in a compressed text chunk. Decoders try all chunks.
+	PNGChunk string
+}
+
+// depth returns the number of low order bits used in each channel
+func (opts Options) depth() int {
+	if opts.Depth == 0 {
+		return 1
+	}
+	return opts.Depth
+}
+
+// channels returns the channels carrying the message
+func (opts Options) channels() Channels {
+	if opts.Channels == 0 {
+		return RGB
+	}
+	return opts.Channels
+}
+
+// validate checks the options can be used for encoding
+func (opts Options) validate() error {
+	return opts.validateDepth(MaxDepth)
+}
+
+// validateDepth checks the options can be used for encoding images accepting up to maxDepth bits per channel
+func (opts Options) validateDepth(maxDepth int) error {
+	if depth := opts.depth(); depth < 1 || depth > maxDepth {
+		return ErrInvalidDepth
+	}
+	if opts.channels()&^RGBA != 0 {
+		return ErrInvalidChannels
+	}
+	if opts.MatrixEmbedding && opts.depth() > 1 {
+		return ErrMatrixDepth
+	}
+	if opts.Cost != nil && opts.depth() > 1 {
+		return ErrCostDepth
+	}
+	if opts.Cost != nil && opts.MatrixEmbedding {
+		return ErrMatrixCost
+	}
+	if opts.TextureThreshold < 0 {
+		return ErrInvalidTextureThreshold
+	}
+	if opts.TextureThreshold > 0 && opts.LSBMatching {
+		return ErrAdaptiveMatching
+	}
+	if opts.ErrorCorrection < 0 || opts.ErrorCorrection > MaxErrorCorrection {
+		return ErrInvalidErrorCorrection
+	}
+	if opts.ErrorCorrection > 0 && (opts.MatrixEmbedding || opts.Cost != nil) {
+		return ErrErrorCorrectionCoding
+	}
+	return nil
+}
+
+// carrier returns the carrier used to embed messages in the image with these options, at the given depth
+func (opts Options) carrier(rgbImage *image.NRGBA, depth int) carrier {
+	return opts.protect(opts.sampleCarrier(rgbImage, depth))
+}
+
+// protect wraps the carrier with the error correction requested by the options
+func (opts Options) protect(c carrier) carrier {
+	if opts.ErrorCorrection > 0 {
+		return newFECCarrier(c, opts.ErrorCorrection)
+	}
+	return c
+}
+
+// sampleCarrier returns the carrier storing the stream in the samples of the image, before any error correction
+func (opts Options) sampleCarrier(rgbImage *image.NRGBA, depth int) codedCarrier {
+	if len(opts.Key) == 0 && depth == 1 && opts.channels() == RGB && !opts.LSBMatching && !opts.selective() {
+		return sequentialCarrier{rgbImage}
+	}
+	var key []byte
+	if len(opts.Key) > 0 {
+		key = opts.Key
+	}
+	c := newSlotCarrier(rgbImage, key, depth, opts.channels())
+	c.matching = opts.LSBMatching
+	c.texture = opts.TextureThreshold
+	c.region = opts.region(rgbImage.Bounds().Dx(), rgbImage.Bounds().Dy())
+	return c
+}
+
+// sampleOptions reports whether options only applying to the samples of truecolor images are set
+func (opts Options) sampleOptions() bool {
+	return opts.depth() != 1 || opts.channels() != RGB || opts.LSBMatching || opts.MatrixEmbedding || opts.Cost != nil || opts.selective()
+}
+
+// selective reports whether some pixels are skipped depending on their content or position
+func (opts Options) selective() bool {
+	return opts.channels()&Alpha != 0 || opts.TextureThreshold > 0 || opts.masked()
+}
+
+// locate finds the depth the message was embedded with, returning its carrier and header
+// Each depth up to maxDepth is tried in turn, until a header recording the depth it was read with is found.
+func (opts Options) locate(rgbImage *image.NRGBA, maxDepth int) (carrier, header, error) {
+	return opts.locateWith(maxDepth, func(depth int) carrier {
+		return opts.carrier(rgbImage, depth)
+	})
+}
+
+// locateWith finds the depth the message was embedded with in the carriers returned for each depth up to maxDepth
+func (opts Options) locateWith(maxDepth int, carrierAt func(depth int) carrier) (carrier, header, error) {
+	var firstErr error
+	for depth := 1; depth <= maxDepth; depth++ {
+		c := carrierAt(depth)
+		h, err := readHeader(c)
+		if err == nil && h.embeddingDepth() == depth && h.errorCorrection() == opts.ErrorCorrection {
+			return c, h, nil
+		}
+		if depth == 1 {
+			firstErr = err // report errors as seen with the default depth
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrInvalidHeader
+	}
+	return nil, header{}, firstErr
+}
+
+// passphrase returns the passphrase used to seal the message, or nil when it is not encrypted
+func (opts Options) passphrase() []byte {
+	if len(opts.Passphrase) == 0 {
+		return nil
+	}
+	return opts.Passphrase
+}
+
+// overhead returns the number of bytes added to the message on top of the fixed header
+func (opts Options) overhead() int {
+	var h header
+	var tag int
+	if opts.passphrase() != nil {
+		h.flags |= flagEncrypted
+		tag = EncryptionOverhead - encryptionParamsSize
+	}
+	h.setDepth(opts.depth())
+	if opts.Cost != nil {
+		h.setSTC(stc{})
+	}
+	h.setErrorCorrection(opts.ErrorCorrection)
+	return h.size() - headerSize + tag
+}
@@ -0,0 +1,413 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeDecodeWithKey(t *testing.T) {
+	opts := Options{Key: []byte("secret key")}
+	cover := newTestImage(60, 60)
+
+	w := new(bytes.Buffer)
+	err := NewEncoder(WithOptions(opts)).Encode(w, cover, bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	msg, err := NewDecoder(WithOptions(opts)).Decode(decodeImg)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	if _, err = NewDecoder(WithOptions(Options{Key: []byte("wrong key")})).Decode(decodeImg); err != ErrInvalidHeader {
+		log.Printf("Uncaught wrong key: %v", err)
+		t.FailNow()
+	}
+	if _, err = DecodeAuto(decodeImg); err != ErrInvalidHeader {
+		log.Printf("Uncaught keyed message decoded without key: %v", err)
+		t.FailNow()
+	}
+
+	// the message must be scattered over the whole image, not only its left columns
+	changed := imageToNRGBA(decodeImg)
+	for x := 30; x < 60; x++ {
+		for y := 0; y < 60; y++ {
+			if changed.NRGBAAt(x, y) != cover.NRGBAAt(x, y) {
+				return
+			}
+		}
+	}
+	log.Print("message was not scattered over the image")
+	t.FailNow()
+}
+
+func TestEncodeDecodeWithKeyAndPassphrase(t *testing.T) {
+	opts := Options{Key: []byte("secret key"), Passphrase: []byte("passphrase")}
+
+	w := new(bytes.Buffer)
+	err := NewEncoder(WithOptions(opts)).Encode(w, newTestImage(60, 60), bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	msg, err := NewDecoder(WithOptions(opts)).Decode(decodeImg)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	if _, err = NewDecoder(WithOptions(Options{Key: opts.Key})).Decode(decodeImg); err != ErrPassphraseRequired {
+		log.Printf("Uncaught encrypted message: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestMaxEncodeSizeOptions(t *testing.T) {
+	img := newTestImage(60, 60)
+	if NewEncoder(WithOptions(Options{Key: []byte("key")})).MaxEncodeSize(img) != MaxEncodeSize(img) {
+		log.Print("keyed traversal changed the capacity")
+		t.FailNow()
+	}
+	if NewEncoder(WithOptions(Options{Passphrase: []byte("passphrase")})).MaxEncodeSize(img) != MaxEncodeSize(img)-EncryptionOverhead {
+		log.Print("encryption overhead not accounted")
+		t.FailNow()
+	}
+
+	message := make([]byte, NewEncoder(WithOptions(Options{Key: []byte("key")})).MaxEncodeSize(img)+1)
+	w := new(bytes.Buffer)
+	if err := NewEncoder(WithOptions(Options{Key: []byte("key")})).Encode(w, img, message); err == nil {
+		log.Printf("Uncaught error: message too large for image")
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeWithDepth(t *testing.T) {
+	for depth := 1; depth <= MaxDepth; depth++ {
+		for _, key := range [][]byte{nil, []byte("secret key")} {
+			opts := Options{Key: key, Depth: depth}
+			cover := newTestImage(30, 30)
+			message := make([]byte, NewEncoder(WithOptions(opts)).MaxEncodeSize(cover))
+			for i := range message {
+				message[i] = bitmessage[i%len(bitmessage)]
+			}
+
+			w := new(bytes.Buffer)
+			err := NewEncoder(WithOptions(opts)).Encode(w, cover, message)
+			if err != nil {
+				log.Printf("Error Encoding file with depth %d: %v", depth, err)
+				t.FailNow()
+			}
+			decodeImg, _, err := image.Decode(w)
+			if err != nil {
+				log.Println("Failed to Decode Image")
+				t.FailNow()
+			}
+
+			msg, err := NewDecoder(WithOptions(Options{Key: key})).Decode(decodeImg) // the depth is discovered from the header
+			if err != nil {
+				log.Printf("Error decoding message with depth %d: %v", depth, err)
+				t.FailNow()
+			}
+			if !bytes.Equal(msg, message) {
+				log.Printf("messages dont match with depth %d", depth)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestDepthCapacity(t *testing.T) {
+	img := newTestImage(100, 100)
+	single := NewEncoder(WithOptions(Options{})).MaxEncodeSize(img)
+	if single != MaxEncodeSize(img) {
+		log.Print("default depth changed the capacity")
+		t.FailNow()
+	}
+	if quad := NewEncoder(WithOptions(Options{Depth: 4})).MaxEncodeSize(img); quad < 4*single {
+		log.Printf("depth 4 capacity %d is less than 4 times %d", quad, single)
+		t.FailNow()
+	}
+
+	w := new(bytes.Buffer)
+	for _, depth := range []int{-1, MaxDepth + 1} {
+		if err := NewEncoder(WithOptions(Options{Depth: depth})).Encode(w, img, bitmessage); err != ErrInvalidDepth {
+			log.Printf("Uncaught invalid depth %d: %v", depth, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSlotCarrierMatchesSequential(t *testing.T) {
+	sequential := newTestImage(40, 40)
+	slots := newTestImage(40, 40)
+
+	sequentialCarrier{sequential}.write(bitmessage)
+	newSlotCarrier(slots, nil, 1, RGB).write(bitmessage)
+
+	if !bytes.Equal(sequential.Pix, slots.Pix) {
+		log.Print("slot carrier does not follow the sequential order")
+		t.FailNow()
+	}
+	if !bytes.Equal(newSlotCarrier(slots, nil, 1, RGB).read(3, 10), bitmessage[3:13]) {
+		log.Print("slot carrier read does not match")
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeWithChannels(t *testing.T) {
+	for _, channels := range []Channels{Red, Blue | Alpha, RGBA, Alpha} {
+		opts := Options{Key: []byte("secret key"), Channels: channels, Depth: 2}
+		cover := newTestImage(60, 60)
+
+		w := new(bytes.Buffer)
+		err := NewEncoder(WithOptions(opts)).Encode(w, cover, bitmessage)
+		if err != nil {
+			log.Printf("Error Encoding file with channels %b: %v", channels, err)
+			t.FailNow()
+		}
+		decodeImg, _, err := image.Decode(w)
+		if err != nil {
+			log.Println("Failed to Decode Image")
+			t.FailNow()
+		}
+
+		msg, err := NewDecoder(WithOptions(opts)).Decode(decodeImg)
+		if err != nil {
+			log.Printf("Error decoding message with channels %b: %v", channels, err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Printf("messages dont match with channels %b", channels)
+			t.FailNow()
+		}
+
+		// channels that were not selected must be left untouched
+		stego := imageToNRGBA(decodeImg)
+		for i := range stego.Pix {
+			if channels&(1<<uint(i%4)) == 0 && stego.Pix[i] != cover.Pix[i] {
+				log.Printf("unselected channel %d was modified with channels %b", i%4, channels)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestAlphaChannelSkipsTransparentPixels(t *testing.T) {
+	cover := newTestImage(60, 60)
+	for x := 0; x < 60; x++ {
+		for y := 0; y < 60; y += 2 {
+			cover.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 255, A: uint8(x % 4)})
+		}
+	}
+	original := imageToNRGBA(cover)
+	opts := Options{Channels: RGBA, Depth: 2}
+
+	// only half of the pixels are opaque enough to carry bits
+	if NewEncoder(WithOptions(opts)).MaxEncodeSize(cover) >= NewEncoder(WithOptions(opts)).MaxEncodeSize(newTestImage(60, 60)) {
+		log.Print("transparent pixels were counted in the capacity")
+		t.FailNow()
+	}
+
+	w := new(bytes.Buffer)
+	err := NewEncoder(WithOptions(opts)).Encode(w, cover, bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	stego := imageToNRGBA(decodeImg)
+	for x := 0; x < 60; x++ {
+		for y := 0; y < 60; y += 2 {
+			if stego.NRGBAAt(x, y) != original.NRGBAAt(x, y) {
+				log.Printf("transparent pixel %d,%d was modified", x, y)
+				t.FailNow()
+			}
+		}
+	}
+
+	msg, err := NewDecoder(WithOptions(opts)).Decode(decodeImg)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match")
+		t.FailNow()
+	}
+}
+
+func TestInvalidChannels(t *testing.T) {
+	w := new(bytes.Buffer)
+	if err := NewEncoder(WithOptions(Options{Channels: 1 << 5})).Encode(w, newTestImage(60, 60), bitmessage); err != ErrInvalidChannels {
+		log.Printf("Uncaught invalid channels: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeOptionsStream(t *testing.T) {
+	opts := Options{Key: []byte("secret key"), Depth: 2}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(NewEncoder(WithOptions(opts)).Encode(w, newTestImage(60, 60), bitmessage))
+	}()
+
+	msg, err := NewDecoder(WithOptions(opts)).DecodeReader(r)
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match")
+		t.FailNow()
+	}
+}
+
+func TestLSBMatching(t *testing.T) {
+	cover := newTestImage(60, 60)
+	for x := 0; x < 60; x++ {
+		cover.SetNRGBA(x, 0, color.NRGBA{R: 0, G: 255, B: 0, A: 255})
+		cover.SetNRGBA(x, 1, color.NRGBA{R: 255, G: 0, B: 255, A: 2})
+	}
+	original := imageToNRGBA(cover)
+
+	for _, opts := range []Options{
+		{LSBMatching: true},
+		{LSBMatching: true, Key: []byte("secret key"), Channels: RGBA},
+		{LSBMatching: true, Depth: 3, Channels: RGBA},
+	} {
+		stego, err := EmbedImage(cover, bitmessage, opts)
+		if err != nil {
+			log.Printf("Error embedding message %v", err)
+			t.FailNow()
+		}
+		replaced, _ := EmbedImage(cover, bitmessage, Options{Key: opts.Key, Depth: opts.Depth, Channels: opts.Channels})
+
+		pix, replacedPix := stego.(*image.NRGBA).Pix, replaced.(*image.NRGBA).Pix
+		limit := 1 << uint(opts.depth()-1)
+		moved := false
+		for i := range pix {
+			// samples move by at most half the period, unless clamping leaves only the replaced value
+			diff, replacedDiff := abs(int(pix[i])-int(original.Pix[i])), abs(int(replacedPix[i])-int(original.Pix[i]))
+			if diff > limit && diff != replacedDiff {
+				log.Printf("sample %d moved from %d to %d", i, original.Pix[i], pix[i])
+				t.FailNow()
+			}
+			if pix[i] != replacedPix[i] {
+				moved = true
+			}
+		}
+		if !moved {
+			log.Print("LSB matching produced the same image as LSB replacement")
+			t.FailNow()
+		}
+
+		msg, err := NewDecoder(WithOptions(Options{Key: opts.Key, Channels: opts.Channels})).Decode(stego)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+	}
+}
+
+func TestAdaptiveEmbedding(t *testing.T) {
+	// the left half of the cover is flat, the right half noisy
+	rng := rand.New(rand.NewSource(1))
+	cover := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.NRGBA{R: 90, G: 160, B: 220, A: 255}
+			if x >= 50 {
+				c = color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			}
+			cover.SetNRGBA(x, y, c)
+		}
+	}
+
+	for _, opts := range []Options{
+		{TextureThreshold: 40},
+		{TextureThreshold: 40, Key: []byte("secret key"), Depth: 2},
+		{TextureThreshold: 40, Cost: TextureCost},
+	} {
+		if size := NewEncoder(WithOptions(opts)).MaxEncodeSize(cover); size >= NewEncoder(WithOptions(Options{Depth: opts.Depth})).MaxEncodeSize(cover)*3/5 {
+			log.Printf("smooth pixels were counted in the capacity: %d", size)
+			t.FailNow()
+		}
+
+		stego, err := EmbedImage(cover, bitmessage, opts)
+		if err != nil {
+			log.Printf("Error embedding message %v", err)
+			t.FailNow()
+		}
+		result := stego.(*image.NRGBA)
+		for x := 0; x < 49; x++ { // the last flat column borders the noisy half
+			for y := 0; y < 100; y++ {
+				if result.NRGBAAt(x, y) != cover.NRGBAAt(x, y) {
+					log.Printf("smooth pixel %d,%d was modified", x, y)
+					t.FailNow()
+				}
+			}
+		}
+
+		msg, err := NewDecoder(WithOptions(Options{Key: opts.Key, TextureThreshold: opts.TextureThreshold})).Decode(stego)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+		if _, err = NewDecoder(WithOptions(Options{Key: opts.Key})).Decode(stego); err == nil {
+			log.Print("message decoded without the texture threshold")
+			t.FailNow()
+		}
+	}
+
+	if _, err := EmbedImage(cover, bitmessage, Options{TextureThreshold: 40, LSBMatching: true}); err != ErrAdaptiveMatching {
+		log.Printf("Uncaught adaptive LSB matching: %v", err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(cover, bitmessage, Options{TextureThreshold: -1}); err != ErrInvalidTextureThreshold {
+		log.Printf("Uncaught negative texture threshold: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,248 @@
+package steganography
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// Palette images (GIF, paletted PNG) store an index in a color table for each pixel. Changing the low bits of the index
+// may pick an unrelated color, and converting the image to truecolor changes its format. Instead, like EzStego, the palette
+// is ordered so that neighbouring colors are close, and each pixel carries the parity of the position of its color in
+// that order. Embedding swaps a color for its neighbour in the pair it belongs to. The palette itself never changes,
+// so decoders rebuild the same order.
+
+// ErrUnsupportedPaletteOption is returned when palette embedding is combined with options working on truecolor samples
+var ErrUnsupportedPaletteOption = errors.New("palette embedding only supports key, passphrase, compression and error correction options")
+
+// paletteOrder returns the palette indices chained by color proximity: starting from the darkest color,
+// each color is followed by the closest remaining one. Ties are broken by index.
+// Translucent colors, such as the transparent color of GIF images, are left out: swapping one for an opaque color would show.
+func paletteOrder(palette color.Palette) []int {
+	colors := make([]color.NRGBA, len(palette))
+	used := make([]bool, len(colors))
+	opaque := 0
+	for i, c := range palette {
+		colors[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+		if colors[i].A == 0xff {
+			opaque++
+		} else {
+			used[i] = true
+		}
+	}
+
+	order := make([]int, 0, opaque)
+	current := -1
+	for len(order) < opaque {
+		next := -1
+		best := 0
+		for i, c := range colors {
+			if used[i] {
+				continue
+			}
+			var d int
+			if current < 0 {
+				d = 299*int(c.R) + 587*int(c.G) + 114*int(c.B) // luminance of the first color
+			} else {
+				d = colorDistance(colors[current], c)
+			}
+			if next < 0 || d < best {
+				next, best = i, d
+			}
+		}
+		used[next] = true
+		order = append(order, next)
+		current = next
+	}
+	return order
+}
+
+// colorDistance returns the squared euclidean distance between two colors, alpha included
+func colorDistance(a, b color.NRGBA) int {
+	dr, dg, db, da := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B), int(a.A)-int(b.A)
+	return dr*dr + dg*dg + db*db + da*da
+}
+
+// paletteCarrier stores one bit in each pixel of a paletted image, as the parity of the position of its color in the palette order
+// Pixels are numbered column by column like the other carriers, and visited in order or in a pseudo-random order derived from a key.
+// Pixels whose color has no neighbour to be swapped with, the last one of an odd sized order or a translucent one, carry nothing.
+type paletteCarrier struct {
+	img       *image.Paletted
+	key       []byte // nil walks the pixels in order
+	order     []int  // palette indices in color order
+	positions []int  // position of each palette index in the order, -1 for colors not paired
+}
+
+// newPaletteCarrier creates the carrier embedding in the pixels of the image
+func newPaletteCarrier(img *image.Paletted, key []byte) paletteCarrier {
+	c := paletteCarrier{img: img, key: key, order: paletteOrder(img.Palette)}
+	c.positions = make([]int, 256)
+	for i := range c.positions {
+		c.positions[i] = -1
+	}
+	for position, index := range c.order[:len(c.order)/2*2] {
+		c.positions[index] = position
+	}
+	return c
+}
+
+// pixels returns a function yielding the Pix positions of the pixels carrying bits, in traversal order
+// The function returns -1 once all pixels have been visited.
+func (c paletteCarrier) pixels() func() int {
+	bounds := c.img.Bounds()
+	n := bounds.Dx() * bounds.Dy()
+	var order traversal = new(sequentialOrder)
+	if c.key != nil {
+		order = newPermutation(c.key, n)
+	}
+	drawn := 0
+	return func() int {
+		for drawn < n {
+			pixel := order.next()
+			drawn++
+			offset := c.img.PixOffset(bounds.Min.X+pixel/bounds.Dy(), bounds.Min.Y+pixel%bounds.Dy())
+			if c.positions[c.img.Pix[offset]] >= 0 {
+				return offset
+			}
+		}
+		return -1
+	}
+}
+
+func (c paletteCarrier) capacity() int {
+	usable := 0
+	for next := newPaletteCarrier(c.img, nil).pixels(); next() >= 0; { // the count does not depend on the order
+		usable++
+	}
+	return usable / 8
+}
+
+func (c paletteCarrier) write(data []byte) {
+	next := c.pixels()
+	for i := 0; i < len(data)*8; i++ {
+		offset := next()
+		if offset < 0 {
+			return
+		}
+		position := c.positions[c.img.Pix[offset]]
+		if byte(position&1) != getBitFromByte(data[i/8], i%8) {
+			c.img.Pix[offset] = uint8(c.order[position^1]) // the other color of the pair
+		}
+	}
+}
+
+func (c paletteCarrier) read(offset, length uint32) []byte {
+	next := c.pixels()
+	message := make([]byte, length)
+
+	skip := int(offset) * 8
+	for i := 0; i < skip+len(message)*8; i++ {
+		position := next()
+		if position < 0 {
+			break
+		}
+		if i >= skip {
+			j := i - skip
+			message[j/8] = setBitInByte(message[j/8], uint32(j%8), byte(c.positions[c.img.Pix[position]]&1))
+		}
+	}
+	return message
+}
+
+// validatePalette checks the options can be used to embed messages in paletted images, animated GIF and JPEG images
+// Only the Key, Passphrase, Compress and ErrorCorrection options are supported: the others work on the samples of truecolor images.
+func (opts Options) validatePalette() error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.sampleOptions() {
+		return ErrUnsupportedPaletteOption
+	}
+	return nil
+}
+
+// paletteCarrier returns the carrier embedding in the pixels of the paletted image with these options
+func (opts Options) paletteCarrier(img *image.Paletted) carrier {
+	var key []byte
+	if len(opts.Key) > 0 {
+		key = opts.Key
+	}
+	return opts.protect(newPaletteCarrier(img, key))
+}
+
+// EmbedPaletted encodes the message into a copy of the paletted cover, which keeps its palette
+// Options working on samples are refused with ErrUnsupportedPaletteOption, see validatePalette.
+func (e *Encoder) EmbedPaletted(cover *image.Paletted, message []byte) (*image.Paletted, error) {
+	opts := e.opts
+	if err := opts.validatePalette(); err != nil {
+		return nil, err
+	}
+	h, message, err := opts.container(message)
+	if err != nil {
+		return nil, err
+	}
+
+	stego := &image.Paletted{
+		Pix:     append([]uint8{}, cover.Pix...),
+		Stride:  cover.Stride,
+		Rect:    cover.Rect,
+		Palette: cover.Palette,
+	}
+	c := opts.paletteCarrier(stego)
+	if messageCapacity(c.capacity()) < uint32(h.size()-headerSize+len(message)) {
+		return nil, ErrMessageTooLarge
+	}
+	c.write(append(h.marshal(), message...))
+	return stego, nil
+}
+
+// EncodeGIF encodes the message into the paletted cover, and writes the result as GIF to w
+func (e *Encoder) EncodeGIF(w io.Writer, cover *image.Paletted, message []byte) error {
+	stego, err := e.EmbedPaletted(cover, message)
+	if err != nil {
+		return err
+	}
+	return gif.Encode(w, stego, nil)
+}
+
+// EncodePalettedPNG encodes the message into the paletted cover, and writes the result as a paletted PNG to w
+func (e *Encoder) EncodePalettedPNG(w io.Writer, cover *image.Paletted, message []byte) error {
+	stego, err := e.EmbedPaletted(cover, message)
+	if err != nil {
+		return err
+	}
+	return e.opts.pngEncoder().Encode(w, stego)
+}
+
+// MaxEncodeSizePaletted returns how many bytes can be stored in the paletted image by EmbedPaletted
+func (e *Encoder) MaxEncodeSizePaletted(img *image.Paletted) uint32 {
+	opts := e.opts
+	if opts.validatePalette() != nil {
+		return 0
+	}
+	size, overhead := messageCapacity(opts.paletteCarrier(img).capacity()), uint32(opts.overhead())
+	if size < overhead {
+		return 0
+	}
+	return size - overhead
+}
+
+// DecodePaletted returns the message embedded by EmbedPaletted in the paletted image
+func (d *Decoder) DecodePaletted(img *image.Paletted) (message []byte, err error) {
+	opts := d.opts
+	if err = opts.validatePalette(); err != nil {
+		return nil, err
+	}
+	c := opts.paletteCarrier(img)
+	h, err := readHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	if h.embeddingDepth() != 1 || h.errorCorrection() != opts.ErrorCorrection {
+		return nil, ErrInvalidHeader
+	}
+	message, _, err = readMessage(c, h, opts.passphrase())
+	return message, err
+}
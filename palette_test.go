@@ -0,0 +1,147 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+// newPalettedImage returns an image of random pixels using the palette
+func newPalettedImage(width, height int, p color.Palette) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), p)
+	rng := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(len(p)))
+	}
+	return img
+}
+
+func TestPaletteOrder(t *testing.T) {
+	p := color.Palette{
+		color.NRGBA{250, 250, 250, 255},
+		color.NRGBA{0, 0, 0, 255},
+		color.NRGBA{200, 0, 0, 255},
+		color.NRGBA{10, 10, 10, 255},
+		color.NRGBA{240, 250, 250, 255},
+		color.NRGBA{210, 0, 0, 255},
+	}
+	order := paletteOrder(p)
+	expected := []int{1, 3, 2, 5, 4, 0}
+	for i := range expected {
+		if order[i] != expected[i] {
+			log.Printf("palette order %v, expected %v", order, expected)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEmbedPaletted(t *testing.T) {
+	message := []byte("hidden in the palette indices")
+	cover := newPalettedImage(64, 48, palette.WebSafe)
+	original := append([]uint8{}, cover.Pix...)
+	pairs := newPaletteCarrier(cover, nil).positions
+
+	for _, opts := range []Options{
+		{},
+		{Key: []byte("key")},
+		{Passphrase: []byte("passphrase")},
+		{Key: []byte("key"), ErrorCorrection: 8, Compress: true},
+	} {
+		stego, err := NewEncoder(WithOptions(opts)).EmbedPaletted(cover, message)
+		if err != nil {
+			log.Printf("Error embedding with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		if !bytes.Equal(cover.Pix, original) {
+			log.Print("cover was modified")
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder(WithOptions(opts)).DecodePaletted(stego); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+
+		// every change swaps a color for its neighbour in the palette order
+		for i := range original {
+			if stego.Pix[i] != original[i] && pairs[stego.Pix[i]] != pairs[original[i]]^1 {
+				log.Printf("pixel %d changed from color %d to color %d, which are not paired", i, original[i], stego.Pix[i])
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestEncodeGIFAndPalettedPNG(t *testing.T) {
+	cover := newPalettedImage(40, 40, palette.Plan9)
+	message := []byte("written back with the original palette")
+	encoder := NewEncoder(WithKey([]byte("key")))
+
+	var gifData, pngData bytes.Buffer
+	if err := encoder.EncodeGIF(&gifData, cover, message); err != nil {
+		log.Printf("Error encoding GIF %v", err)
+		t.FailNow()
+	}
+	if err := encoder.EncodePalettedPNG(&pngData, cover, message); err != nil {
+		log.Printf("Error encoding PNG %v", err)
+		t.FailNow()
+	}
+	fromGIF, err := gif.Decode(&gifData)
+	if err != nil {
+		log.Printf("Error decoding GIF %v", err)
+		t.FailNow()
+	}
+	fromPNG, err := png.Decode(&pngData)
+	if err != nil {
+		log.Printf("Error decoding PNG %v", err)
+		t.FailNow()
+	}
+
+	for _, img := range []image.Image{fromGIF, fromPNG} {
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			log.Printf("expected a paletted image, got %T", img)
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder(WithKey([]byte("key"))).DecodePaletted(paletted); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+			t.FailNow()
+		}
+		if colors := len(paletted.Palette); colors != len(cover.Palette) {
+			log.Printf("palette has %d colors, expected %d", colors, len(cover.Palette))
+			t.FailNow()
+		}
+	}
+}
+
+func TestPalettedCapacity(t *testing.T) {
+	// the last color of an odd sized palette has no pair, and its pixels carry nothing
+	p := color.Palette{color.Black, color.White, color.NRGBA{128, 128, 128, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, 32, 32), p)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 2) // black, paired with grey, and white
+	}
+	if capacity := newPaletteCarrier(img, nil).capacity(); capacity != 32*32/2/8 {
+		log.Printf("capacity %d, expected %d", capacity, 32*32/2/8)
+		t.FailNow()
+	}
+
+	size := NewEncoder().MaxEncodeSizePaletted(img)
+	if _, err := NewEncoder().EmbedPaletted(img, make([]byte, size)); err != nil {
+		log.Printf("message of MaxEncodeSizePaletted bytes does not fit: %v", err)
+		t.FailNow()
+	}
+	if _, err := NewEncoder().EmbedPaletted(img, make([]byte, size+1)); err != ErrMessageTooLarge {
+		log.Printf("expected ErrMessageTooLarge, got %v", err)
+		t.FailNow()
+	}
+	if _, err := NewEncoder(WithLSBMatching()).EmbedPaletted(img, []byte("m")); err != ErrUnsupportedPaletteOption {
+		log.Printf("expected ErrUnsupportedPaletteOption, got %v", err)
+		t.FailNow()
+	}
+}
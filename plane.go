@@ -0,0 +1,118 @@
+package steganography
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// Carriers embed in NRGBA images, to which covers are converted. 16-bit images keep their precision: instead of converting
+// them to 8-bit NRGBA, the low order byte of each of their samples is copied to a plane, an NRGBA image the carriers embed in
+// as usual, and copied back once the message is embedded. As embedding only changes bits of the low order byte,
+// up to 8 bits per sample can be used. Grayscale images keep their single channel the same way, instead of being
+// expanded to three color channels.
+
+// MaxDepth16 is the maximum number of low order bits that can be used in each channel of 16-bit images
+const MaxDepth16 = 8
+
+// ErrUnsupportedDeepOption is returned when texture or cost based embedding is used with 16-bit images,
+// as the low order bytes the plane holds say little about the content of the image
+var ErrUnsupportedDeepOption = errors.New("texture and cost based embedding are not supported with 16-bit images")
+
+// plane holds the low order byte of the samples of a copy of an image, as an NRGBA image
+// Grayscale images store their single channel in the red channel of the plane, the other channels being unused.
+// For other 8-bit images, the plane is the copy of the image converted to NRGBA.
+type plane struct {
+	rgbImage   *image.NRGBA
+	img        image.Image // the copy of the image, starting at 0, 0, nil when it is rgbImage
+	pix        []byte      // Pix slice of the copy
+	samples    int         // samples per pixel of the copy, 1 or 4
+	sampleSize int         // bytes per sample of the copy, 1 or 2
+	maxDepth   int         // maximum embedding depth
+}
+
+// newPlane returns the plane of a copy of the image
+func newPlane(img image.Image) plane {
+	bounds := img.Bounds()
+	rect := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	p := plane{sampleSize: 2, maxDepth: MaxDepth16}
+
+	switch src := img.(type) {
+	case *image.NRGBA64:
+		copied := image.NewNRGBA64(rect)
+		copyRows(copied.Pix, copied.Stride, src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y), rect.Dy())
+		p.img, p.pix, p.samples = copied, copied.Pix, 4
+	case *image.RGBA64:
+		copied := image.NewNRGBA64(rect)
+		draw.Draw(copied, rect, src, bounds.Min, draw.Src) // exact for opaque images, which is what 16-bit RGB PNG files decode to
+		p.img, p.pix, p.samples = copied, copied.Pix, 4
+	case *image.Gray16:
+		copied := image.NewGray16(rect)
+		copyRows(copied.Pix, copied.Stride, src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y), rect.Dy())
+		p.img, p.pix, p.samples = copied, copied.Pix, 1
+	case *image.Gray:
+		copied := image.NewGray(rect)
+		copyRows(copied.Pix, copied.Stride, src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y), rect.Dy())
+		p = plane{img: copied, pix: copied.Pix, samples: 1, sampleSize: 1, maxDepth: MaxDepth}
+	default:
+		return plane{rgbImage: imageToNRGBA(img), maxDepth: MaxDepth}
+	}
+
+	p.rgbImage = image.NewNRGBA(rect)
+	for i := range p.rgbImage.Pix {
+		if p.samples == 1 && i%4 != 0 {
+			if i%4 == 3 {
+				p.rgbImage.Pix[i] = 0xff // opaque, so the alpha channel never gets in the way
+			}
+			continue
+		}
+		p.rgbImage.Pix[i] = p.pix[p.sampleOffset(i)]
+	}
+	return p
+}
+
+// copyRows copies the rows of the source image starting at offset to the destination image, which has the same size and starts at 0, 0
+func copyRows(dst []byte, dstStride int, src []byte, srcStride, offset, rows int) {
+	for y := 0; y < rows; y++ {
+		copy(dst[y*dstStride:(y+1)*dstStride], src[offset+y*srcStride:])
+	}
+}
+
+// sampleOffset returns the position in the Pix slice of the copy of the low order byte of the sample at the given plane position
+func (p plane) sampleOffset(i int) int {
+	return (i/4*p.samples+i%4)*p.sampleSize + p.sampleSize - 1
+}
+
+// image returns the copy of the image, with the low order bytes of its samples taken from the plane
+func (p plane) image() image.Image {
+	if p.img == nil {
+		return p.rgbImage
+	}
+	for i, b := range p.rgbImage.Pix {
+		if i%4 < p.samples {
+			p.pix[p.sampleOffset(i)] = b
+		}
+	}
+	return p.img
+}
+
+// options checks the options can be used with the image of the plane, and returns them adapted to it
+// Images with a single channel only have the red channel of the plane, used whatever the channels selected by default.
+func (p plane) options(opts Options) (Options, error) {
+	if err := opts.validateDepth(p.maxDepth); err != nil {
+		return opts, err
+	}
+	if p.img == nil {
+		return opts, nil
+	}
+	if p.sampleSize == 2 && (opts.Cost != nil || opts.TextureThreshold > 0) {
+		return opts, ErrUnsupportedDeepOption
+	}
+	if p.samples == 1 {
+		if opts.channels() != RGB {
+			return opts, ErrInvalidChannels
+		}
+		opts.Channels = Red
+	}
+	return opts, nil
+}
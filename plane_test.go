@@ -0,0 +1,269 @@
+package steganography
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"testing"
+)
+
+// newTestImage16 creates a 16-bit gradient image with the given size
+func newTestImage16(width, height int) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA64{R: uint16(x * 997), G: uint16(y * 883), B: uint16(x*y*31 + 7), A: uint16(0xffff - x)})
+		}
+	}
+	return img
+}
+
+func TestEncodeDecode16Bit(t *testing.T) {
+	message := []byte("sixteen bits per sample")
+	cover := newTestImage16(40, 30)
+	for _, opts := range []Options{
+		{},
+		{Depth: 8},
+		{Key: []byte("key"), Depth: 5, LSBMatching: true},
+		{Channels: RGBA, Depth: 3},
+		{MatrixEmbedding: true},
+		{ErrorCorrection: 10, Passphrase: []byte("passphrase")},
+		{Compress: true, Depth: 2},
+	} {
+		var buf bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).Encode(&buf, cover, message); err != nil {
+			log.Printf("Error encoding with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		stego, err := png.Decode(&buf)
+		if err != nil {
+			log.Printf("Error decoding PNG %v", err)
+			t.FailNow()
+		}
+		encoded, ok := stego.(*image.NRGBA64)
+		if !ok {
+			log.Printf("encoded %T as %T", cover, stego)
+			t.FailNow()
+		}
+		for i := range cover.Pix {
+			if i%2 == 0 && encoded.Pix[i] != cover.Pix[i] {
+				log.Printf("high order byte %d changed with %+v", i, opts)
+				t.FailNow()
+			}
+		}
+		decoded, err := NewDecoder(WithOptions(opts)).Decode(stego)
+		if err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestLegacyReaders16Bit(t *testing.T) {
+	message := []byte("read without a Decoder")
+	opaque := image.NewRGBA64(image.Rect(0, 0, 30, 30))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = byte(i * 13)
+		if i%8 >= 6 {
+			opaque.Pix[i] = 0xff
+		}
+	}
+	for _, cover := range []image.Image{newTestImage16(30, 30), opaque, image.NewGray16(image.Rect(0, 0, 40, 40))} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, cover, message); err != nil {
+			log.Printf("Error encoding %T: %v", cover, err)
+			t.FailNow()
+		}
+		stego, err := png.Decode(&buf)
+		if err != nil {
+			log.Printf("Error decoding the PNG: %v", err)
+			t.FailNow()
+		}
+		if size := GetMessageSizeFromImage(stego); size != uint32(len(message)) {
+			log.Printf("%T: GetMessageSizeFromImage returned %d, expected %d", cover, size, len(message))
+			t.FailNow()
+		}
+		if decoded := Decode(uint32(len(message)), stego); !bytes.Equal(decoded, message) {
+			log.Printf("%T: Decode returned %q", cover, decoded)
+			t.FailNow()
+		}
+		if decoded, err := DecodeE(uint32(len(message)), stego); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("%T: DecodeE returned %q, %v", cover, decoded, err)
+			t.FailNow()
+		}
+		if decoded, err := DecodeMessage(stego); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("%T: DecodeMessage returned %q, %v", cover, decoded, err)
+			t.FailNow()
+		}
+
+		buf.Reset()
+		if err := EncodeEncrypted(&buf, cover, message, []byte("passphrase")); err != nil {
+			log.Printf("Error encrypting into %T: %v", cover, err)
+			t.FailNow()
+		}
+		stego, err = png.Decode(&buf)
+		if err != nil {
+			log.Printf("Error decoding the PNG: %v", err)
+			t.FailNow()
+		}
+		if fmt.Sprintf("%T", stego) != fmt.Sprintf("%T", cover) {
+			log.Printf("EncodeEncrypted converted %T to %T", cover, stego)
+			t.FailNow()
+		}
+		if decoded, err := DecodeEncrypted(stego, []byte("passphrase")); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("DecodeEncrypted returned %q, %v", decoded, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestEncodeDecodeOpaque16Bit(t *testing.T) {
+	cover := image.NewRGBA64(image.Rect(0, 0, 25, 25))
+	for i := range cover.Pix {
+		cover.Pix[i] = byte(i * 13)
+		if i%8 >= 6 {
+			cover.Pix[i] = 0xff // opaque
+		}
+	}
+	message := []byte("opaque")
+	var buf bytes.Buffer
+	if err := NewEncoder(WithOptions(Options{Depth: 6})).Encode(&buf, cover, message); err != nil {
+		log.Printf("Error encoding an opaque 16-bit image: %v", err)
+		t.FailNow()
+	}
+	stego, err := png.Decode(&buf)
+	if err != nil || fmt.Sprintf("%T", stego) != fmt.Sprintf("%T", cover) {
+		log.Printf("Error decoding PNG as %T: %v", cover, err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder(WithOptions(Options{Depth: 6})).Decode(stego); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("Error decoding an opaque 16-bit image: %q, %v", decoded, err)
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeGray16(t *testing.T) {
+	cover := image.NewGray16(image.Rect(0, 0, 32, 20))
+	for i := range cover.Pix {
+		cover.Pix[i] = byte(i * 7)
+	}
+	if size, expected := MaxEncodeSize(cover), uint32(32*20/8-headerSize); size != expected {
+		log.Printf("MaxEncodeSize %d, expected %d", size, expected)
+		t.FailNow()
+	}
+	if size, expected := NewEncoder(WithOptions(Options{Depth: 8})).MaxEncodeSize(cover), uint32(32*20-headerSize-1); size != expected {
+		log.Printf("MaxEncodeSize at depth 8 %d, expected %d", size, expected)
+		t.FailNow()
+	}
+
+	message := bytes.Repeat([]byte("gray"), 10)
+	opts := Options{Depth: 4, Key: []byte("key")}
+	var buf bytes.Buffer
+	if err := NewEncoder(WithOptions(opts)).Encode(&buf, cover, message); err != nil {
+		log.Printf("Error encoding a 16-bit grayscale image: %v", err)
+		t.FailNow()
+	}
+	stego, err := png.Decode(&buf)
+	if err != nil || fmt.Sprintf("%T", stego) != fmt.Sprintf("%T", cover) {
+		log.Printf("Error decoding PNG as %T: %v", cover, err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder(WithOptions(opts)).Decode(stego); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("Error decoding a 16-bit grayscale image: %q, %v", decoded, err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(cover, message, Options{Channels: Blue}); err != ErrInvalidChannels {
+		log.Printf("expected ErrInvalidChannels, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestInvalid16BitOptions(t *testing.T) {
+	cover := newTestImage16(20, 20)
+	if _, err := EmbedImage(cover, []byte("m"), Options{Depth: MaxDepth16 + 1}); err != ErrInvalidDepth {
+		log.Printf("expected ErrInvalidDepth, got %v", err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(newTestImage(20, 20), []byte("m"), Options{Depth: MaxDepth16}); err != ErrInvalidDepth {
+		log.Printf("expected ErrInvalidDepth for an 8-bit image, got %v", err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(cover, []byte("m"), Options{TextureThreshold: 10}); err != ErrUnsupportedDeepOption {
+		log.Printf("expected ErrUnsupportedDeepOption, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeGray(t *testing.T) {
+	cover := image.NewGray(image.Rect(10, 10, 58, 42))
+	for i := range cover.Pix {
+		cover.Pix[i] = byte(i*i + i/7)
+	}
+	if size, expected := MaxEncodeSize(cover), uint32(48*32/8-headerSize); size != expected {
+		log.Printf("MaxEncodeSize %d, expected %d", size, expected)
+		t.FailNow()
+	}
+
+	message := []byte("a single luminance channel")
+	for _, opts := range []Options{{}, {Depth: 3, Key: []byte("key")}, {TextureThreshold: 200}, {Cost: TextureCost}} {
+		var buf bytes.Buffer
+		if err := NewEncoder(WithOptions(opts)).Encode(&buf, cover, message); err != nil {
+			log.Printf("Error encoding a grayscale image with %+v: %v", opts, err)
+			t.FailNow()
+		}
+		stego, err := png.Decode(&buf)
+		if err != nil || fmt.Sprintf("%T", stego) != fmt.Sprintf("%T", cover) {
+			log.Printf("Error decoding PNG as %T: %v", cover, err)
+			t.FailNow()
+		}
+		if decoded, err := NewDecoder(WithOptions(opts)).Decode(stego); err != nil || !bytes.Equal(decoded, message) {
+			log.Printf("Error decoding a grayscale image with %+v: %q, %v", opts, decoded, err)
+			t.FailNow()
+		}
+	}
+	if _, err := EmbedImage(cover, message, Options{Channels: Green}); err != ErrInvalidChannels {
+		log.Printf("expected ErrInvalidChannels, got %v", err)
+		t.FailNow()
+	}
+}
+
+func TestLegacyReadersGray(t *testing.T) {
+	cover := image.NewGray(image.Rect(0, 0, 48, 32))
+	for i := range cover.Pix {
+		cover.Pix[i] = byte(i * 3)
+	}
+	message := []byte("gray without a Decoder")
+	var buf bytes.Buffer
+	if err := Encode(&buf, cover, message); err != nil {
+		log.Printf("Error encoding message %v", err)
+		t.FailNow()
+	}
+	stego, err := png.Decode(&buf)
+	if err != nil {
+		log.Printf("Error decoding the PNG %v", err)
+		t.FailNow()
+	}
+	if _, ok := stego.(*image.Gray); !ok {
+		log.Printf("expected a grayscale PNG, decoded %T", stego)
+		t.FailNow()
+	}
+	if size := GetMessageSizeFromImage(stego); size != uint32(len(message)) {
+		log.Printf("GetMessageSizeFromImage returned %d, expected %d", size, len(message))
+		t.FailNow()
+	}
+	if decoded := Decode(uint32(len(message)), stego); !bytes.Equal(decoded, message) {
+		log.Printf("Decode returned %q", decoded)
+		t.FailNow()
+	}
+	if decoded, err := DecodeE(uint32(len(message)), stego); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("DecodeE returned %q, %v", decoded, err)
+		t.FailNow()
+	}
+	if decoded, err := DecodeMessage(stego); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("DecodeMessage returned %q, %v", decoded, err)
+		t.FailNow()
+	}
+}
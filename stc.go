@@ -0,0 +1,230 @@
+package steganography
+
+import (
+	"errors"
+	"math"
+)
+
+// Syndrome-trellis codes (Filler, Judas and Fridrich, 2011) embed a message in the least significant bits of the samples
+// while minimizing the total cost of the changed samples. The message is the syndrome H·y of the stego bits y, where the
+// parity check matrix H is built by placing a small height x width submatrix along its diagonal, one row lower for each
+// message bit. The Viterbi algorithm finds the stego bits of lowest cost over the trellis of the 2^height partial syndromes.
+// The decoder only multiplies the stego bits by H, so it needs neither the costs nor the cover.
+
+const (
+	// stcHeight is the constraint height used for new messages, the trellis has 2^stcHeight states
+	stcHeight = 7
+	// maxSTCHeight bounds the constraint height accepted when decoding
+	maxSTCHeight = 10
+	// maxSTCWidth bounds the number of samples used per message bit, and so the memory used by the trellis
+	maxSTCWidth = 255
+)
+
+// ErrCostTooHigh is returned when the message can not be embedded without changing samples whose cost is infinite
+var ErrCostTooHigh = errors.New("message can not be embedded without changing forbidden samples")
+
+// stc describes a syndrome-trellis code: message bit i is the parity of some of the samples of the height groups of width samples ending with group i
+type stc struct {
+	height int // rows of the submatrix
+	width  int // columns of the submatrix, the number of samples per message bit
+}
+
+// newSTC returns the code embedding bits message bits in at most the given number of samples
+func newSTC(samples, bits int) stc {
+	code := stc{height: stcHeight, width: maxSTCWidth}
+	if bits > 0 && samples/bits < maxSTCWidth {
+		code.width = samples / bits
+	}
+	return code
+}
+
+// columns returns the columns of the submatrix, pseudo-random numbers derived from its dimensions
+// Every column has its first and last rows set, which gives codes of good efficiency.
+func (code stc) columns() []int {
+	columns := make([]int, code.width)
+	state := uint32(code.height)<<16 | uint32(code.width) | 1<<31
+	for j := range columns {
+		state ^= state << 13 // xorshift32
+		state ^= state >> 17
+		state ^= state << 5
+		columns[j] = int(state)&(1<<uint(code.height)-1) | 1 | 1<<uint(code.height-1)
+	}
+	return columns
+}
+
+// rows returns the mask of the submatrix rows used by message bit i of bits, which are cut at the last message bit
+func (code stc) rows(i, bits int) int {
+	if left := bits - i; left < code.height {
+		return 1<<uint(left) - 1
+	}
+	return 1<<uint(code.height) - 1
+}
+
+// embed returns the stego bits of lowest total cost whose syndrome is the message, all slices holding one bit per byte
+// The cover and costs hold len(message)*width samples.
+func (code stc) embed(cover []byte, costs []float64, message []byte) ([]byte, error) {
+	states := 1 << uint(code.height)
+	words := (states + 63) / 64
+	columns := code.columns()
+	inf := math.Inf(1)
+
+	weights := make([]float64, states)
+	next := make([]float64, states)
+	for s := 1; s < states; s++ {
+		weights[s] = inf
+	}
+
+	// path records, for each sample and state, whether the best way to reach the state sets the stego bit
+	path := make([]uint64, len(cover)*words)
+	n := 0
+	for i, bit := range message {
+		rows := code.rows(i, len(message))
+		for _, column := range columns {
+			column &= rows
+			var keep, flip float64 // cost of the stego bit being 0 and 1
+			if cover[n] == 0 {
+				flip = costs[n]
+			} else {
+				keep = costs[n]
+			}
+			p := path[n*words:]
+			for s := 0; s < states; s++ {
+				w0 := weights[s] + keep
+				w1 := weights[s^column] + flip
+				if w1 < w0 {
+					next[s] = w1
+					p[s/64] |= 1 << uint(s%64)
+				} else {
+					next[s] = w0
+				}
+			}
+			weights, next = next, weights
+			n++
+		}
+
+		// the lowest row is complete: keep the states matching the message bit, and move to the next row
+		for s := 0; s < states/2; s++ {
+			next[s] = weights[s<<1|int(bit)]
+		}
+		for s := states / 2; s < states; s++ {
+			next[s] = inf
+		}
+		weights, next = next, weights
+	}
+	if math.IsInf(weights[0], 1) {
+		return nil, ErrCostTooHigh
+	}
+
+	stego := make([]byte, len(cover))
+	state := 0
+	for i := len(message) - 1; i >= 0; i-- {
+		state = state<<1 | int(message[i])
+		rows := code.rows(i, len(message))
+		for j := len(columns) - 1; j >= 0; j-- {
+			n--
+			if path[n*words+state/64]>>uint(state%64)&1 != 0 {
+				stego[n] = 1
+				state ^= columns[j] & rows
+			}
+		}
+	}
+	return stego, nil
+}
+
+// extract returns the bits message bits embedded in the stego bits, one bit per byte
+func (code stc) extract(stego []byte, bits int) []byte {
+	columns := code.columns()
+	message := make([]byte, bits)
+	state := 0
+	n := 0
+	for i := range message {
+		rows := code.rows(i, bits)
+		for _, column := range columns {
+			if stego[n] != 0 {
+				state ^= column & rows
+			}
+			n++
+		}
+		message[i] = byte(state & 1)
+		state >>= 1
+	}
+	return message
+}
+
+// unpackBits returns the bits of data, most significant first, one bit per byte
+func unpackBits(data []byte) []byte {
+	bits := make([]byte, len(data)*8)
+	for i := range bits {
+		bits[i] = getBitFromByte(data[i/8], i%8)
+	}
+	return bits
+}
+
+// packBits returns the bytes holding the bits, most significant first, given one bit per byte
+func packBits(bits []byte) []byte {
+	data := make([]byte, len(bits)/8)
+	for i := range bits[:len(data)*8] {
+		data[i/8] = setBitInByte(data[i/8], uint32(i%8), bits[i])
+	}
+	return data
+}
+
+func (c slotCarrier) writeSTC(head, payload []byte, code stc, cost CostFunction) error {
+	next := c.samples()
+	heads := make([]int, len(head)*8)
+	for i := range heads {
+		heads[i] = next()
+	}
+
+	// costs are computed on the cover, before the header is written
+	message := unpackBits(payload)
+	offsets := make([]int, len(message)*code.width)
+	cover := make([]byte, len(offsets))
+	costs := make([]float64, len(offsets))
+	for i := range offsets {
+		offsets[i] = next()
+		cover[i] = c.rgbImage.Pix[offsets[i]] & 1
+		x, y, ch := c.coordinates(offsets[i])
+		costs[i] = cost(c.rgbImage, x, y, ch)
+	}
+	stego, err := code.embed(cover, costs, message)
+	if err != nil {
+		return err
+	}
+
+	rng := c.matchingSource()
+	for i, offset := range heads {
+		c.store(offset, getBitFromByte(head[i/8], i%8), 1, 0, rng)
+	}
+	for i, offset := range offsets {
+		if stego[i] != cover[i] {
+			c.store(offset, stego[i], 1, 0, rng)
+		}
+	}
+	return nil
+}
+
+func (c slotCarrier) readSTC(offset, length uint32, code stc) []byte {
+	next := c.samples()
+	for i := 0; i < int(offset)*8; i++ {
+		next()
+	}
+
+	stego := make([]byte, int(length)*8*code.width)
+	for i := range stego {
+		position := next()
+		if position < 0 {
+			break
+		}
+		stego[i] = c.rgbImage.Pix[position] & 1
+	}
+	return packBits(code.extract(stego, int(length)*8))
+}
+
+func (c sequentialCarrier) writeSTC(head, payload []byte, code stc, cost CostFunction) error {
+	return c.slots().writeSTC(head, payload, code, cost)
+}
+
+func (c sequentialCarrier) readSTC(offset, length uint32, code stc) []byte {
+	return c.slots().readSTC(offset, length, code)
+}
@@ -0,0 +1,194 @@
+package steganography
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSTCEmbedExtract(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range []struct{ bits, width int }{{1, 1}, {5, 3}, {64, 2}, {200, 10}, {100, 255}} {
+		code := stc{height: stcHeight, width: test.width}
+		message := make([]byte, test.bits)
+		for i := range message {
+			message[i] = byte(rng.Intn(2))
+		}
+		cover := make([]byte, test.bits*test.width)
+		costs := make([]float64, len(cover))
+		for i := range cover {
+			cover[i] = byte(rng.Intn(2))
+			costs[i] = rng.Float64()
+		}
+
+		stego, err := code.embed(cover, costs, message)
+		if err != nil {
+			log.Printf("Error embedding %d bits: %v", test.bits, err)
+			t.FailNow()
+		}
+		if !bytes.Equal(code.extract(stego, test.bits), message) {
+			log.Printf("messages dont match for %d bits with width %d", test.bits, test.width)
+			t.FailNow()
+		}
+
+		changes := 0
+		for i := range stego {
+			if stego[i] != cover[i] {
+				changes++
+			}
+		}
+		if test.width >= 10 && changes >= test.bits/2 {
+			log.Printf("%d changes for %d bits with width %d", changes, test.bits, test.width)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSTCWetSamples(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	code := stc{height: stcHeight, width: 4}
+	message := make([]byte, 100)
+	cover := make([]byte, len(message)*code.width)
+	costs := make([]float64, len(cover))
+	for i := range cover {
+		cover[i] = byte(rng.Intn(2))
+		costs[i] = 1
+		if i%2 == 0 {
+			costs[i] = math.Inf(1)
+		}
+	}
+	for i := range message {
+		message[i] = byte(rng.Intn(2))
+	}
+
+	stego, err := code.embed(cover, costs, message)
+	if err != nil {
+		log.Printf("Error embedding: %v", err)
+		t.FailNow()
+	}
+	for i := 0; i < len(stego); i += 2 {
+		if stego[i] != cover[i] {
+			log.Printf("wet sample %d was changed", i)
+			t.FailNow()
+		}
+	}
+	if !bytes.Equal(code.extract(stego, len(message)), message) {
+		log.Print("messages dont match")
+		t.FailNow()
+	}
+
+	for i := range costs {
+		costs[i] = math.Inf(1)
+	}
+	if _, err = code.embed(cover, costs, message); err != ErrCostTooHigh {
+		log.Printf("Uncaught forbidden changes: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncodeDecodeWithCost(t *testing.T) {
+	cover := newTestImage(100, 100)
+	for _, opts := range []Options{
+		{Cost: UniformCost},
+		{Cost: TextureCost, Key: []byte("secret key"), Channels: RGBA},
+		{Cost: TextureCost, LSBMatching: true, Passphrase: []byte("passphrase")},
+	} {
+		w := new(bytes.Buffer)
+		if err := NewEncoder(WithOptions(opts)).Encode(w, cover, bitmessage); err != nil {
+			log.Printf("Error Encoding file %v", err)
+			t.FailNow()
+		}
+		decodeImg, _, err := image.Decode(w)
+		if err != nil {
+			log.Println("Failed to Decode Image")
+			t.FailNow()
+		}
+
+		msg, err := NewDecoder(WithOptions(Options{Key: opts.Key, Channels: opts.Channels, Passphrase: opts.Passphrase})).Decode(decodeImg)
+		if err != nil {
+			log.Printf("Error decoding message %v", err)
+			t.FailNow()
+		}
+		if !bytes.Equal(msg, bitmessage) {
+			log.Print("messages dont match")
+			t.FailNow()
+		}
+	}
+
+	stego, err := EmbedImage(cover, bitmessage, Options{Cost: UniformCost})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(Decode(GetMessageSizeFromImage(stego), stego), bitmessage) {
+		log.Print("Decode does not read syndrome-trellis coded messages")
+		t.FailNow()
+	}
+
+	// the largest message fits with a code of width 1
+	message := make([]byte, NewEncoder(WithOptions(Options{Cost: UniformCost})).MaxEncodeSize(cover))
+	stego, err = EmbedImage(cover, message, Options{Cost: UniformCost})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if msg, err := DecodeAuto(stego); err != nil || !bytes.Equal(msg, message) {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTextureCostAvoidsSmoothRegions(t *testing.T) {
+	// the left half of the cover is flat, the right half noisy
+	rng := rand.New(rand.NewSource(3))
+	cover := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+			if x >= 50 {
+				c = color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+			}
+			cover.SetNRGBA(x, y, c)
+		}
+	}
+
+	// without a key the header is written in the first columns, so only the payload is counted
+	stego, err := EmbedImage(cover, bitmessage, Options{Cost: TextureCost, Key: []byte("secret key")})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	smooth, textured := 0, 0
+	result := stego.(*image.NRGBA)
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			if result.NRGBAAt(x, y) != cover.NRGBAAt(x, y) {
+				if x < 50 {
+					smooth++
+				} else {
+					textured++
+				}
+			}
+		}
+	}
+	if smooth*4 > textured {
+		log.Printf("%d changes in the smooth region, %d in the textured one", smooth, textured)
+		t.FailNow()
+	}
+}
+
+func TestCostOptions(t *testing.T) {
+	cover := newTestImage(60, 60)
+	if _, err := EmbedImage(cover, bitmessage, Options{Cost: UniformCost, Depth: 2}); err != ErrCostDepth {
+		log.Printf("Uncaught cost depth: %v", err)
+		t.FailNow()
+	}
+	if _, err := EmbedImage(cover, bitmessage, Options{Cost: UniformCost, MatrixEmbedding: true}); err != ErrMatrixCost {
+		log.Printf("Uncaught matrix embedding with a cost: %v", err)
+		t.FailNow()
+	}
+}
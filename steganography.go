@@ -5,13 +5,23 @@ import (
 	"bytes"
 	"errors"
 	"image"
-	"image/color"
 	"image/draw"
-	"image/png"
+	"io"
+)
+
+var (
+	// ErrMessageTooLarge is returned when encoding a message larger than the capacity of the image
+	ErrMessageTooLarge = errors.New("message too large for image")
+	// ErrLengthExceedsCapacity is returned when the length of the message to decode exceeds the capacity of the image
+	ErrLengthExceedsCapacity = errors.New("message length exceeds the capacity of the image")
+	// ErrNoPayload is returned when the image does not carry a message
+	ErrNoPayload = errors.New("image does not carry a message")
 )
 
 // EncodeNRGBA encodes a given string into the input image using least significant bit encryption (LSB steganography)
-// The minnimum image size is 24 pixels for one byte. For each additional byte, it is necessary 3 more pixels.
+// The message is prefixed with a self-describing header (magic, version, flags, length and CRC-32 of the message).
+// The minnimum image size is 48 pixels for one byte. For each additional byte, it is necessary 3 more pixels.
+// The input image is left untouched, see EmbedImage.
 /*
 	Input:
 		writeBuffer *bytes.Buffer : the destination of the encoded image bytes
@@ -21,70 +31,117 @@ import (
 		bytes buffer ( io.writter ) to create file, or send data.
 */
 func EncodeNRGBA(writeBuffer *bytes.Buffer, rgbImage *image.NRGBA, message []byte) error {
+	return EncodeNRGBAWriter(writeBuffer, rgbImage, message)
+}
 
-	var messageLength = uint32(len(message))
+// EmbedImage encodes a given message into a copy of the cover image as configured by the options, and returns the copy
+// The cover is left untouched, and serializing the result is left to the caller: it must be written in a lossless format, such as PNG.
+/*
+	Input:
+		cover image.Image : image data used in encoding
+		message []byte : byte slice of the message to be encoded
+		opts Options : embedding configuration, the zero value matches Encode
+	Output:
+		image.Image : the image carrying the message (an *image.NRGBA, an *image.Gray for grayscale covers, or an *image.NRGBA64 or *image.Gray16 for 16-bit covers)
+*/
+func EmbedImage(cover image.Image, message []byte, opts Options) (image.Image, error) {
+	return NewEncoder(WithOptions(opts)).Embed(cover, message)
+}
 
-	var width = rgbImage.Bounds().Dx()
-	var height = rgbImage.Bounds().Dy()
-	var c color.NRGBA
-	var bit byte
-	var ok bool
-	//var encodedImage image.Image
-	if MaxEncodeSize(rgbImage) < messageLength+4 {
-		return errors.New("message too large for image")
+// container returns the header describing the message, and the payload following it
+// The message is compressed and encrypted as requested by the options, and the header records the embedding options.
+// The length of compressed messages is sealed with them when they are encrypted, as it would reveal the compression ratio.
+func (opts Options) container(message []byte) (header, []byte, error) {
+	compressed, length := false, len(message)
+	if opts.Compress {
+		var payload []byte
+		if payload, compressed = compress(message); compressed {
+			message = payload
+		}
+	}
+	passphrase := opts.passphrase()
+	if compressed && passphrase != nil {
+		one, two, three, four := splitToBytes(uint32(length))
+		message = append([]byte{one, two, three, four}, message...)
 	}
 
-	one, two, three, four := splitToBytes(messageLength)
+	h := newHeader(message)
+	if compressed {
+		h.setCompression(compressionDeflate, length)
+	}
+	h.setDepth(opts.depth())
+	h.setErrorCorrection(opts.ErrorCorrection)
+	if passphrase != nil {
+		return seal(h, message, passphrase) // compress before encrypting, as ciphertexts do not compress
+	}
+	return h, message, nil
+}
 
-	message = append([]byte{four}, message...)
-	message = append([]byte{three}, message...)
-	message = append([]byte{two}, message...)
-	message = append([]byte{one}, message...)
+// embedPayload encodes the header followed by the payload into the image, through the carrier matching the options
+func embedPayload(rgbImage *image.NRGBA, opts Options, h header, message []byte) error {
 
-	ch := make(chan byte, 100)
+	c := opts.carrier(rgbImage, h.embeddingDepth())
+	if opts.Cost != nil {
+		h.setSTC(stc{}) // the code dimensions are only known once the capacity is checked
+	}
 
-	go getNextBitFromString(message, ch)
+	var messageLength = uint32(h.size() - headerSize + len(message)) // messageCapacity already accounts for the fixed header
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
+	if messageCapacity(c.capacity()) < messageLength {
+		return ErrMessageTooLarge
+	}
 
-			c = rgbImage.NRGBAAt(x, y) // get the color at this pixel
+	if opts.Cost != nil {
+		code := newSTC((c.capacity()-h.size())*8, len(message)*8)
+		h.setSTC(code)
+		return c.(codedCarrier).writeSTC(h.marshal(), message, code, opts.Cost)
+	}
+	if opts.MatrixEmbedding {
+		// pick the largest code fitting the message after the header, which is always embedded one bit per sample
+		coded := h
+		coded.setMatrix(maxMatrixK)
+		if k := matrixParameter((c.capacity()-coded.size())*8, len(message)*8); k > 1 {
+			h.setMatrix(k)
+			c.(codedCarrier).writeMatrix(h.marshal(), message, k)
+			return nil
+		}
+	}
 
-			/*  RED  */
-			bit, ok = <-ch
-			if !ok { // if we don't have any more bits left in our message
+	c.write(append(h.marshal(), message...)) // prefix the message with the container header
 
-				rgbImage.SetNRGBA(x, y, c)
-				break
-			}
-			setLSB(&c.R, bit)
+	return nil
+}
 
-			/*  GREEN  */
-			bit, ok = <-ch
-			if !ok {
-				rgbImage.SetNRGBA(x, y, c)
-				break
-			}
-			setLSB(&c.G, bit)
+// embedNRGBA writes the message into the least significant bits of the image, walking pixels column by column
+// The Pix slice is accessed directly: each column is walked by adding the stride to the offset of its first pixel.
+/*
+	Input:
+		rgbImage image.NRGBA : image data used in encoding
+		message []byte : byte slice to be encoded, including its header
+*/
+func embedNRGBA(rgbImage *image.NRGBA, message []byte) {
 
-			/*  BLUE  */
-			bit, ok = <-ch
-			if !ok {
-				rgbImage.SetNRGBA(x, y, c)
-				break
-			}
-			setLSB(&c.B, bit)
+	bounds := rgbImage.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pix, stride := rgbImage.Pix, rgbImage.Stride
+
+	bits := len(message) * 8
+	bit := 0
 
-			rgbImage.SetNRGBA(x, y, c)
+	for x := 0; x < width && bit < bits; x++ {
+		offset := rgbImage.PixOffset(bounds.Min.X+x, bounds.Min.Y) // first pixel of the column
+		for y := 0; y < height && bit < bits; y++ {
+			for ch := 0; ch < 3 && bit < bits; ch++ { // red, green and blue
+				setLSB(&pix[offset+ch], getBitFromByte(message[bit>>3], bit&7))
+				bit++
+			}
+			offset += stride
 		}
 	}
-
-	err := png.Encode(writeBuffer, rgbImage)
-	return err
 }
 
 // Encode encodes a given string into the input image using least significant bit encryption (LSB steganography)
-// The minnimum image size is 23 pixels
+// The minnimum image size is 48 pixels
 // It wraps EncodeNRGBA making the conversion from image.Image to image.NRGBA
 /*
 	Input:
@@ -95,14 +152,34 @@ func EncodeNRGBA(writeBuffer *bytes.Buffer, rgbImage *image.NRGBA, message []byt
 		bytes buffer ( io.writter ) to create file, or send data.
 */
 func Encode(writeBuffer *bytes.Buffer, pictureInputFile image.Image, message []byte) error {
+	return EncodeWriter(writeBuffer, pictureInputFile, message)
+}
 
-	rgbImage := imageToNRGBA(pictureInputFile)
-
-	return EncodeNRGBA(writeBuffer, rgbImage, message)
+// EncodeWriter encodes a given message into the input image, writing the PNG to any io.Writer (see Encode)
+/*
+	Input:
+		w io.Writer : the destination of the encoded image bytes (a file, an HTTP response...)
+		pictureInputFile image.Image : image data used in encoding
+		message []byte : byte slice of the message to be encoded
+*/
+func EncodeWriter(w io.Writer, pictureInputFile image.Image, message []byte) error {
+	return NewEncoder().Encode(w, pictureInputFile, message)
+}
 
+// EncodeNRGBAWriter encodes a given message into the input image, writing the PNG to any io.Writer (see EncodeNRGBA)
+/*
+	Input:
+		w io.Writer : the destination of the encoded image bytes (a file, an HTTP response...)
+		rgbImage image.NRGBA : image data used in encoding
+		message []byte : byte slice of the message to be encoded
+*/
+func EncodeNRGBAWriter(w io.Writer, rgbImage *image.NRGBA, message []byte) error {
+	return NewEncoder().Encode(w, rgbImage, message)
 }
 
 // decodeNRGBA gets messages from pictures using LSB steganography, decode the message from the picture and return it as a sequence of bytes
+// The Pix slice is accessed directly, starting from the pixel holding the first bit after startOffset.
+// When the image is too small, only the bytes it can hold are returned.
 /*
 	Input:
 		startOffset uint32 : number of bytes used to declare size of message
@@ -113,74 +190,45 @@ func Encode(writeBuffer *bytes.Buffer, pictureInputFile image.Image, message []b
 */
 func decodeNRGBA(startOffset uint32, msgLen uint32, rgbImage *image.NRGBA) (message []byte) {
 
-	var byteIndex uint32
-	var bitIndex uint32
-
-	width := rgbImage.Bounds().Dx()
-	height := rgbImage.Bounds().Dy()
-
-	var c color.NRGBA
-	var lsb byte
-
-	message = append(message, 0)
-
-	// iterate through every pixel in the image and stitch together the message bit by bit
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-
-			c = rgbImage.NRGBAAt(x, y) // get the color of the pixel
-
-			/*  RED  */
-			lsb = getLSB(c.R)                                                    // get the least significant bit from the red component of this pixel
-			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb) // add this bit to the message
-			bitIndex++
-
-			if bitIndex > 7 { // when we have filled up a byte, move on to the next byte
-				bitIndex = 0
-				byteIndex++
-
-				if byteIndex >= msgLen+startOffset {
-					return message[startOffset : msgLen+startOffset]
-				}
-
-				message = append(message, 0)
-			}
-
-			/*  GREEN  */
-			lsb = getLSB(c.G)
-			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb)
-			bitIndex++
-
-			if bitIndex > 7 {
-
-				bitIndex = 0
-				byteIndex++
-
-				if byteIndex >= msgLen+startOffset {
-					return message[startOffset : msgLen+startOffset]
-				}
-
-				message = append(message, 0)
-			}
+	bounds := rgbImage.Bounds()
+	height := bounds.Dy()
+	pix, stride := rgbImage.Pix, rgbImage.Stride
 
-			/*  BLUE  */
-			lsb = getLSB(c.B)
-			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb)
-			bitIndex++
-
-			if bitIndex > 7 {
-				bitIndex = 0
-				byteIndex++
-
-				if byteIndex >= msgLen+startOffset {
-					return message[startOffset : msgLen+startOffset]
+	available := uint64(bounds.Dx()*height*3) / 8
+	if uint64(startOffset) >= available {
+		return []byte{}
+	}
+	if uint64(startOffset)+uint64(msgLen) > available {
+		msgLen = uint32(available - uint64(startOffset))
+	}
+	message = make([]byte, msgLen)
+
+	// locate the channel holding the first bit of the message
+	slot := int(startOffset) * 8
+	x, y, ch := slot/3/height, slot/3%height, slot%3
+	offset := rgbImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+
+	// iterate through the pixels and stitch together the message bit by bit
+	for i := range message {
+		var b byte
+		for bitIndex := 0; bitIndex < 8; bitIndex++ {
+			b = b<<1 | getLSB(pix[offset+ch])
+
+			ch++
+			if ch == 3 { // move on to the next pixel of the column
+				ch = 0
+				y++
+				offset += stride
+				if y == height { // move on to the next column
+					y = 0
+					x++
+					offset = rgbImage.PixOffset(bounds.Min.X+x, bounds.Min.Y)
 				}
-
-				message = append(message, 0)
 			}
 		}
+		message[i] = b
 	}
-	return
+	return message
 }
 
 // decode gets messages from pictures using LSB steganography, decode the message from the picture and return it as a sequence of bytes
@@ -202,6 +250,7 @@ func decode(startOffset uint32, msgLen uint32, pictureInputFile image.Image) (me
 
 // Decode gets messages from pictures using LSB steganography, decode the message from the picture and return it as a sequence of bytes
 // It wraps EncodeNRGBA making the conversion from image.Image to image.NRGBA
+// Images carrying a container header are detected automatically, otherwise the legacy headerless format is assumed.
 /*
 	Input:
 		msgLen uint32 : size of the message to be decoded
@@ -210,56 +259,275 @@ func decode(startOffset uint32, msgLen uint32, pictureInputFile image.Image) (me
 		message []byte decoded from image
 */
 func Decode(msgLen uint32, pictureInputFile image.Image) (message []byte) {
-	return decode(4, msgLen, pictureInputFile) // the offset of 4 skips the "header" where message length is defined
+	c := legacyCarrier(pictureInputFile)
+	if h, err := readHeader(c); err == nil {
+		if h.coded() {
+			h.length = msgLen
+			message, _ = readPayload(c, h)
+			return message
+		}
+		return readAvailable(c, uint32(h.size()), msgLen) // the offset skips the container header
+	}
+	return readAvailable(c, legacyHeaderSize, msgLen) // the offset of 4 skips the "header" where message length is defined
+
+}
+
+// DecodeE gets messages from pictures using LSB steganography like Decode, but checks the length before decoding anything
+// A length exceeding the capacity of the image is reported with ErrLengthExceedsCapacity, so nothing is allocated for it.
+/*
+	Input:
+		msgLen uint32 : size of the message to be decoded, see GetMessageSizeFromImage
+		pictureInputFile image.Image : image data used in decoding
+	Output:
+		message []byte decoded from image
+		err error : non nil if the length is not valid for the image
+*/
+func DecodeE(msgLen uint32, pictureInputFile image.Image) (message []byte, err error) {
+	c := legacyCarrier(pictureInputFile)
+
+	offset := uint32(legacyHeaderSize)
+	if h, err := readHeader(c); err == nil {
+		if h.coded() {
+			h.length = msgLen
+			return readPayload(c, h)
+		}
+		offset = uint32(h.size())
+	}
+	if err = checkLength(c, offset, msgLen); err != nil {
+		return nil, err
+	}
+	return c.read(offset, msgLen), nil
+}
+
+// DecodeMessage gets the message from the picture, whether it was encoded with a container header or with the legacy format
+// Headers are validated as DecodeAuto does. For legacy images, lengths of zero or beyond the capacity of the image are
+// reported with ErrNoPayload, as they are what images without a message yield.
+/*
+	Input:
+		pictureInputFile image.Image : image data used in decoding
+	Output:
+		message []byte decoded from image
+		err error : non nil if the image does not carry a valid payload
+*/
+func DecodeMessage(pictureInputFile image.Image) (message []byte, err error) {
+	message, err = NewDecoder().Decode(pictureInputFile)
+	if err != ErrInvalidHeader {
+		return message, err
+	}
+
+	// without a header, fall back to the legacy format
+	c := legacyCarrier(pictureInputFile)
+	size := c.read(0, legacyHeaderSize)
+	if len(size) < legacyHeaderSize {
+		return nil, ErrNoPayload
+	}
+	msgLen := combineToInt(size[0], size[1], size[2], size[3])
+	if msgLen == 0 || checkLength(c, legacyHeaderSize, msgLen) != nil {
+		return nil, ErrNoPayload
+	}
+	return c.read(legacyHeaderSize, msgLen), nil
+}
+
+// legacyCarrier returns the carrier Encode embeds in for the image, which the readers taking or returning a bare length use
+// 16-bit and grayscale images are read through their plane, like the Decoder does.
+func legacyCarrier(img image.Image) carrier {
+	p := newPlane(img)
+	opts, _ := p.options(Options{}) // the default options suit every plane
+	return opts.carrier(p.rgbImage, 1)
+}
+
+// readAvailable reads up to msgLen bytes starting at offset from the carrier, only returning the bytes it can hold
+func readAvailable(c carrier, offset, msgLen uint32) []byte {
+	capacity := uint32(c.capacity())
+	if offset >= capacity {
+		return []byte{}
+	}
+	if msgLen > capacity-offset {
+		msgLen = capacity - offset
+	}
+	return c.read(offset, msgLen)
+}
+
+// checkLength checks a message of msgLen bytes, starting at offset, fits in the carrier
+func checkLength(c carrier, offset, msgLen uint32) error {
+	if uint64(offset)+uint64(msgLen) > uint64(c.capacity()) {
+		return ErrLengthExceedsCapacity
+	}
+	return nil
+}
+
+// DecodeLegacy gets messages from pictures encoded with the legacy headerless format (a bare 4 byte length followed by the message)
+// It never attempts to detect a container header, and is meant for images encoded by older versions of this package.
+/*
+	Input:
+		msgLen uint32 : size of the message to be decoded, see GetLegacyMessageSizeFromImage
+		pictureInputFile image.Image : image data used in decoding
+	Output:
+		message []byte decoded from image
+*/
+func DecodeLegacy(msgLen uint32, pictureInputFile image.Image) (message []byte) {
+	return decode(legacyHeaderSize, msgLen, pictureInputFile)
+}
+
+// DecodeAuto reads the container header written by Encode, and returns the message after validating its length and checksum
+// Images that do not carry a valid header are refused with ErrInvalidHeader, and corrupted messages with ErrChecksumMismatch.
+// Encrypted messages are refused with ErrPassphraseRequired, see DecodeEncrypted.
+/*
+	Input:
+		pictureInputFile image.Image : image data used in decoding
+	Output:
+		message []byte decoded from image
+		err error : non nil if the image does not carry a valid payload
+*/
+func DecodeAuto(pictureInputFile image.Image) (message []byte, err error) {
+	return NewDecoder().Decode(pictureInputFile)
+}
+
+// DecodeReader decodes the image read from r (PNG, or any format registered with the image package),
+// and returns the message embedded in it after validating its header and checksum (see DecodeAuto)
+/*
+	Input:
+		r io.Reader : the source of the encoded image bytes (a file, an HTTP request body...)
+	Output:
+		message []byte decoded from image
+		err error : non nil if the image can not be decoded, or does not carry a valid payload
+*/
+func DecodeReader(r io.Reader) (message []byte, err error) {
+	return NewDecoder().DecodeReader(r)
+}
 
+// decodeCorrected locates the header embedded with the options, and reads and validates the payload it describes,
+// also returning the number of bytes repaired by error correction. Depths up to maxDepth are tried.
+// Encrypted payloads are opened with the passphrase, which must be nil for messages that are not encrypted.
+func decodeCorrected(rgbImage *image.NRGBA, opts Options, passphrase []byte, maxDepth int) (message []byte, corrected int, err error) {
+	c, h, err := opts.locate(rgbImage, maxDepth)
+	if err != nil {
+		return nil, 0, err
+	}
+	return readMessage(c, h, passphrase)
+}
+
+// readMessage reads the payload described by the header from the carrier, then checks, decrypts and decompresses it
+func readMessage(c carrier, h header, passphrase []byte) (message []byte, corrected int, err error) {
+	encrypted := h.flags&flagEncrypted != 0
+	if encrypted && passphrase == nil {
+		return nil, 0, ErrPassphraseRequired
+	}
+	if !encrypted && passphrase != nil {
+		return nil, 0, ErrNotEncrypted
+	}
+
+	message, err = readPayload(c, h)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fc, ok := c.(fecCarrier); ok {
+		if fc.state.failed {
+			return nil, 0, ErrTooManyErrors
+		}
+		corrected = fc.state.corrected
+	}
+	if encrypted {
+		// the authentication tag supersedes the checksum, so any modification is reported as ErrAuthentication
+		message, err = open(h, message, passphrase)
+	} else {
+		err = h.verify(message)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if encrypted && h.flags&flagCompressed != 0 {
+		// the length of the decompressed message is sealed in front of it, see container
+		if len(message) < 4 {
+			return nil, 0, ErrInvalidHeader
+		}
+		h.uncompressed = combineToInt(message[0], message[1], message[2], message[3])
+		if h.uncompressed > maxDecompressedSize {
+			return nil, 0, ErrInvalidHeader
+		}
+		message = message[4:]
+	}
+	if h.flags&flagCompressed != 0 {
+		message, err = decompress(h, message)
+	}
+	return message, corrected, err
 }
 
 // MaxEncodeSize given an image will find how many bytes can be stored in that image using least significant bit encoding
-// ((width * height * 3) / 8 ) - 4
+// ((width * height * 3) / 8 ) - 14, or ((width * height) / 8 ) - 14 for grayscale images
 // The result must be at least 4,
 func MaxEncodeSize(img image.Image) uint32 {
-	width := img.Bounds().Dx()
-	height := img.Bounds().Dy()
-	eval := ((width * height * 3) / 8) - 4
+	return NewEncoder().MaxEncodeSize(img)
+}
+
+// messageCapacity removes the container header from the number of bytes an image can hold
+func messageCapacity(capacity int) uint32 {
+	eval := capacity - headerSize
 	if eval < 4 {
 		eval = 0
 	}
 	return uint32(eval)
 }
 
-// GetMessageSizeFromImage gets the size of the message from the first four bytes encoded in the image
+// GetMessageSizeFromImage gets the size of the message from the header encoded in the image
+// Images without a container header are read using the legacy format, where the size is stored in the first four bytes.
 func GetMessageSizeFromImage(pictureInputFile image.Image) (size uint32) {
 
-	sizeAsByteArray := decode(0, 4, pictureInputFile)
+	c := legacyCarrier(pictureInputFile)
+	if h, err := readHeader(c); err == nil {
+		return h.length
+	}
+	sizeAsByteArray := readAvailable(c, 0, legacyHeaderSize)
+	if len(sizeAsByteArray) < legacyHeaderSize {
+		return 0
+	}
 	size = combineToInt(sizeAsByteArray[0], sizeAsByteArray[1], sizeAsByteArray[2], sizeAsByteArray[3])
 	return
 }
 
-// getNextBitFromString each call will return the next subsequent bit in the string
-func getNextBitFromString(byteArray []byte, ch chan byte) {
+// GetLegacyMessageSizeFromImage gets the size of the message from the first four bytes encoded in the image (legacy headerless format)
+func GetLegacyMessageSizeFromImage(pictureInputFile image.Image) (size uint32) {
 
-	var offsetInBytes int
-	var offsetInBitsIntoByte int
-	var choiceByte byte
+	sizeAsByteArray := decode(0, legacyHeaderSize, pictureInputFile)
+	if len(sizeAsByteArray) < legacyHeaderSize {
+		return 0
+	}
+	size = combineToInt(sizeAsByteArray[0], sizeAsByteArray[1], sizeAsByteArray[2], sizeAsByteArray[3])
+	return
+}
 
-	lenOfString := len(byteArray)
+// readHeader reads and validates the container header through the carrier
+func readHeader(c carrier) (header, error) {
+	h, err := parseHeader(c.read(0, headerSize))
+	if err != nil || h.size() == headerSize {
+		return h, err
+	}
+	return h, h.parseExtensions(c.read(0, uint32(h.size())))
+}
 
-	for {
-		if offsetInBytes >= lenOfString {
-			close(ch)
-			return
+// readPayload reads the payload described by the header, after checking it fits in the carrier
+// Coded payloads can only be read from carriers storing one bit per sample.
+func readPayload(c carrier, h header) ([]byte, error) {
+	coded, ok := c.(codedCarrier)
+	if !ok && h.coded() {
+		return nil, ErrInvalidHeader
+	}
+	if h.flags&flagSTC != 0 {
+		if uint64(h.size())*8+uint64(h.length)*8*uint64(h.stc.width) > uint64(c.capacity())*8 {
+			return nil, ErrLengthExceedsCapacity
 		}
-
-		choiceByte = byteArray[offsetInBytes]
-		ch <- getBitFromByte(choiceByte, offsetInBitsIntoByte)
-
-		offsetInBitsIntoByte++
-
-		if offsetInBitsIntoByte >= 8 {
-			offsetInBitsIntoByte = 0
-			offsetInBytes++
+		return coded.readSTC(uint32(h.size()), h.length, h.stc), nil
+	}
+	if k := h.matrixParameter(); k > 1 {
+		if uint64(h.size())*8+uint64(matrixSamples(int(h.length)*8, k)) > uint64(c.capacity())*8 {
+			return nil, ErrLengthExceedsCapacity
 		}
+		return coded.readMatrix(uint32(h.size()), h.length, k), nil
 	}
+	if uint64(h.length)+uint64(h.size()) > uint64(c.capacity()) {
+		return nil, ErrLengthExceedsCapacity
+	}
+	return c.read(uint32(h.size()), h.length), nil
 }
 
 // getLSB given a byte, will return the least significant bit of that byte
@@ -331,6 +599,7 @@ func splitToBytes(x uint32) (one, two, three, four byte) {
 }
 
 // imageToNRGBA converts image.Image to image.NRGBA
+// The result is always a new image, so it can be modified without affecting the source.
 func imageToNRGBA(src image.Image) *image.NRGBA {
 	bounds := src.Bounds()
 
@@ -342,6 +611,14 @@ func imageToNRGBA(src image.Image) *image.NRGBA {
 
 	m = image.NewNRGBA(image.Rect(0, 0, width, height))
 
+	if nrgba, ok := src.(*image.NRGBA); ok { // copy the rows straight from the Pix slice
+		for y := 0; y < height; y++ {
+			offset := nrgba.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(m.Pix[y*m.Stride:(y+1)*m.Stride], nrgba.Pix[offset:offset+width*4])
+		}
+		return m
+	}
+
 	draw.Draw(m, m.Bounds(), src, bounds.Min, draw.Src)
 	return m
 }
@@ -6,7 +6,10 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
+	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"testing"
 )
@@ -15,9 +18,21 @@ var rawInputFilePng = "./examples/stegosaurus.png"
 var rawInputFileJpg = "./examples/stegosaurus.jpg"
 var encodedInputFilePng = "./examples/encoded_stegosaurus.png"
 var encodedInputFileJpg = "./examples/encoded_stegosaurus_from_jpg.png"
+var legacyInputFilePng = "./examples/legacy_encoded_stegosaurus.png"
 
 var bitmessage = []uint8{84, 104, 101, 113, 117, 97, 100, 114, 117, 112, 101, 100, 97, 108, 83, 116, 101, 103, 111, 115, 97, 117, 114, 117, 115, 105, 115, 111, 110, 101, 111, 102, 116, 104, 101, 109, 111, 115, 116, 101, 97, 115, 105, 108, 121, 105, 100, 101, 110, 116, 105, 102, 105, 97, 98, 108, 101, 100, 105, 110, 111, 115, 97, 117, 114, 103, 101, 110, 101, 114, 97, 44, 100, 117, 101, 116, 111, 116, 104, 101, 100, 105, 115, 116, 105, 110, 99, 116, 105, 118, 101, 100, 111, 117, 98, 108, 101, 114, 111, 119, 111, 102, 107, 105, 116, 101, 45, 115, 104, 97, 112, 101, 100, 112, 108, 97, 116, 101, 115, 114, 105, 115, 105, 110, 103, 118, 101, 114, 116, 105, 99, 97, 108, 108, 121, 97, 108, 111, 110, 103, 116, 104, 101, 114, 111, 117, 110, 100, 101, 100, 98, 97, 99, 107, 97, 110, 100, 116, 104, 101, 116, 119, 111, 112, 97, 105, 114, 115, 111, 102, 108, 111, 110, 103, 115, 112, 105, 107, 101, 115, 101, 120, 116, 101, 110, 100, 105, 110, 103, 104, 111, 114, 105, 122, 111, 110, 116, 97, 108, 108, 121, 110, 101, 97, 114, 116, 104, 101, 101, 110, 100, 111, 102, 116, 104, 101, 116, 97, 105, 108, 46, 65, 108, 116, 104, 111, 117, 103, 104, 108, 97, 114, 103, 101, 105, 110, 100, 105, 118, 105, 100, 117, 97, 108, 115, 99, 111, 117, 108, 100, 103, 114, 111, 119, 117, 112, 116, 111, 57, 109, 40, 50, 57, 46, 53, 102, 116, 41, 105, 110, 108, 101, 110, 103, 116, 104, 91, 52, 93, 97, 110, 100, 53, 46, 51, 116, 111, 55, 109, 101, 116, 114, 105, 99, 116, 111, 110, 115, 40, 53, 46, 56, 116, 111, 55, 46, 55, 115, 104, 111, 114, 116, 116, 111, 110, 115, 41, 105, 110, 119, 101, 105, 103, 104, 116, 44, 91, 53, 93, 91, 54, 93, 116, 104, 101, 118, 97, 114, 105, 111, 117, 115, 115, 112, 101, 99, 105, 101, 115, 111, 102, 83, 116, 101, 103, 111, 115, 97, 117, 114, 117, 115, 119, 101, 114, 101, 100, 119, 97, 114, 102, 101, 100, 98, 121, 99, 111, 110, 116, 101, 109, 112, 111, 114, 97, 114, 105, 101, 115, 44, 116, 104, 101, 103, 105, 97, 110, 116, 115, 97, 117, 114, 111, 112, 111, 100, 115, 46, 83, 111, 109, 101, 102, 111, 114, 109, 111, 102, 97, 114, 109, 111, 114, 97, 112, 112, 101, 97, 114, 115, 116, 111, 104, 97, 118, 101, 98, 101, 101, 110, 110, 101, 99, 101, 115, 115, 97, 114, 121, 44, 97, 115, 83, 116, 101, 103, 111, 115, 97, 117, 114, 117, 115, 115, 112, 101, 99, 105, 101, 115, 99, 111, 101, 120, 105, 115, 116, 101, 100, 119, 105, 116, 104, 108, 97, 114, 103, 101, 112, 114, 101, 100, 97, 116, 111, 114, 121, 116, 104, 101, 114, 111, 112, 111, 100, 100, 105, 110, 111, 115, 97, 117, 114, 115, 44, 115, 117, 99, 104, 97, 115, 65, 108, 108, 111, 115, 97, 117, 114, 117, 115, 97, 110, 100, 67, 101, 114, 97, 116, 111, 115, 97, 117, 114, 117, 115, 46}
 
+// newTestImage creates a gradient image with the given size
+func newTestImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 255})
+		}
+	}
+	return img
+}
+
 func TestEncodeFromPngFile(t *testing.T) {
 
 	inFile, err := os.Open(rawInputFilePng)
@@ -121,7 +136,7 @@ func TestDecodeFromPngFile(t *testing.T) {
 
 func TestEncodeDecodeGeneratedSmallImage(t *testing.T) {
 	// Creating image
-	width := 30
+	width := 48
 	height := 1
 
 	upLeft := image.Point{0, 0}
@@ -170,7 +185,7 @@ func TestSmalImage(t *testing.T) {
 		t.FailNow()
 	}
 
-	miniImage = image.Image(image.NewNRGBA(image.Rectangle{image.Point{0, 0}, image.Point{23, 1}}))
+	miniImage = image.Image(image.NewNRGBA(image.Rectangle{image.Point{0, 0}, image.Point{48, 1}}))
 
 	if MaxEncodeSize(miniImage) != 4 {
 		log.Printf("Uncaught minimal image size")
@@ -183,7 +198,7 @@ func TestMessageTooLarge(t *testing.T) {
 	miniImage := image.Image(image.NewNRGBA(image.Rectangle{image.Point{0, 0}, image.Point{24, 1}}))
 	w := new(bytes.Buffer)
 	err := Encode(w, miniImage, bitmessage) // Encode the message into the image file
-	if err == nil {
+	if err != ErrMessageTooLarge {
 		log.Printf("Uncaught error: message too large for image")
 		t.FailNow()
 
@@ -247,3 +262,400 @@ func TestEncodeDecodeGenerateColors(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeWriterDecodeReader(t *testing.T) {
+	outFile, err := ioutil.TempFile("", "steganography-*.png")
+	if err != nil {
+		log.Printf("Error creating file %v", err)
+		t.FailNow()
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	err = EncodeWriter(outFile, newTestImage(60, 60), bitmessage) // Encode the message straight into the file
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+
+	if _, err = outFile.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Error seeking file %v", err)
+		t.FailNow()
+	}
+	msg, err := DecodeReader(outFile) // Read the message straight from the file
+	if err != nil {
+		log.Printf("Error decoding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(msg, bitmessage) {
+		log.Print("messages dont match:")
+		log.Println(string(msg))
+		t.FailNow()
+	}
+
+	if _, err = DecodeReader(bytes.NewReader(bitmessage)); err == nil {
+		log.Print("Uncaught invalid image")
+		t.FailNow()
+	}
+}
+
+func TestEmbedImageLeavesCoverUntouched(t *testing.T) {
+	cover := newTestImage(60, 60)
+	original := imageToNRGBA(cover)
+
+	stego, err := EmbedImage(cover, bitmessage, Options{Key: []byte("key")})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(cover.Pix, original.Pix) {
+		log.Print("EmbedImage modified the cover")
+		t.FailNow()
+	}
+
+	msg, err := NewDecoder(WithOptions(Options{Key: []byte("key")})).Decode(stego)
+	if err != nil || !bytes.Equal(msg, bitmessage) {
+		log.Printf("messages dont match: %v", err)
+		t.FailNow()
+	}
+
+	w := new(bytes.Buffer)
+	if err = EncodeNRGBA(w, cover, bitmessage); err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(cover.Pix, original.Pix) {
+		log.Print("EncodeNRGBA modified the cover")
+		t.FailNow()
+	}
+}
+
+func TestEmbedImageSubImage(t *testing.T) {
+	// a sub image does not start at the origin, and its Pix slice is shared with the parent
+	cover := newTestImage(80, 80).SubImage(image.Rect(10, 20, 70, 80))
+
+	stego, err := EmbedImage(cover, bitmessage, Options{})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if stego.Bounds().Dx() != 60 || stego.Bounds().Dy() != 60 {
+		log.Printf("unexpected bounds %v", stego.Bounds())
+		t.FailNow()
+	}
+
+	msg, err := DecodeAuto(stego)
+	if err != nil || !bytes.Equal(msg, bitmessage) {
+		log.Printf("messages dont match: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeE(t *testing.T) {
+	w := new(bytes.Buffer)
+	err := Encode(w, newTestImage(60, 60), bitmessage)
+	if err != nil {
+		log.Printf("Error Encoding file %v", err)
+		t.FailNow()
+	}
+	decodeImg, _, err := image.Decode(w)
+	if err != nil {
+		log.Println("Failed to Decode Image")
+		t.FailNow()
+	}
+
+	msg, err := DecodeE(GetMessageSizeFromImage(decodeImg), decodeImg)
+	if err != nil || !bytes.Equal(msg, bitmessage) {
+		log.Printf("messages dont match: %v", err)
+		t.FailNow()
+	}
+
+	if _, err = DecodeE(MaxEncodeSize(decodeImg)+1, decodeImg); err != ErrLengthExceedsCapacity {
+		log.Printf("Uncaught length exceeding capacity: %v", err)
+		t.FailNow()
+	}
+	if _, err = DecodeE(1<<32-1, decodeImg); err != ErrLengthExceedsCapacity {
+		log.Printf("Uncaught length exceeding capacity: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	for _, file := range []string{legacyInputFilePng, rawInputFilePng} {
+		inFile, err := os.Open(file)
+		if err != nil {
+			log.Printf("Error opening file %s: %v", file, err)
+			t.FailNow()
+		}
+		img, _, err := image.Decode(bufio.NewReader(inFile))
+		inFile.Close()
+		if err != nil {
+			log.Printf("Error decoding. %v", err)
+			t.FailNow()
+		}
+
+		msg, err := DecodeMessage(img)
+		if file == rawInputFilePng {
+			if err != ErrNoPayload {
+				log.Printf("Uncaught image without payload: %v", err)
+				t.FailNow()
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(msg, bitmessage) {
+			log.Printf("messages dont match: %v", err)
+			t.FailNow()
+		}
+	}
+
+	msg, err := DecodeMessage(newTestImage(10, 10)) // too small for a legacy length
+	if err != ErrNoPayload {
+		log.Printf("Uncaught image without payload: %v %v", msg, err)
+		t.FailNow()
+	}
+
+	stego, err := EmbedImage(newTestImage(60, 60), bitmessage, Options{Depth: 3})
+	if err != nil {
+		log.Printf("Error embedding message %v", err)
+		t.FailNow()
+	}
+	if msg, err = DecodeMessage(stego); err != nil || !bytes.Equal(msg, bitmessage) {
+		log.Printf("messages dont match: %v", err)
+		t.FailNow()
+	}
+}
+
+// referenceEmbedNRGBA is the previous implementation of embedNRGBA, pulling every bit through a channel and accessing pixels with NRGBAAt/SetNRGBA.
+// It is kept to check the output of embedNRGBA is byte identical, and to benchmark it.
+/*
+	Input:
+		rgbImage image.NRGBA : image data used in encoding
+		message []byte : byte slice to be encoded, including its header
+*/
+func referenceEmbedNRGBA(rgbImage *image.NRGBA, message []byte) {
+
+	var width = rgbImage.Bounds().Dx()
+	var height = rgbImage.Bounds().Dy()
+	var c color.NRGBA
+	var bit byte
+	var ok bool
+
+	ch := make(chan byte, 100)
+
+	go referenceNextBit(message, ch)
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+
+			c = rgbImage.NRGBAAt(x, y) // get the color at this pixel
+
+			/*  RED  */
+			bit, ok = <-ch
+			if !ok { // if we don't have any more bits left in our message
+
+				rgbImage.SetNRGBA(x, y, c)
+				break
+			}
+			setLSB(&c.R, bit)
+
+			/*  GREEN  */
+			bit, ok = <-ch
+			if !ok {
+				rgbImage.SetNRGBA(x, y, c)
+				break
+			}
+			setLSB(&c.G, bit)
+
+			/*  BLUE  */
+			bit, ok = <-ch
+			if !ok {
+				rgbImage.SetNRGBA(x, y, c)
+				break
+			}
+			setLSB(&c.B, bit)
+
+			rgbImage.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+// referenceDecodeNRGBA is the previous implementation of decodeNRGBA, kept for comparisons and benchmarks.
+/*
+	Input:
+		startOffset uint32 : number of bytes used to declare size of message
+		msgLen uint32 : size of the message to be decoded
+		pictureInputFile image.NRGBA : image data used in decoding
+	Output:
+		message []byte decoded from image
+*/
+func referenceDecodeNRGBA(startOffset uint32, msgLen uint32, rgbImage *image.NRGBA) (message []byte) {
+
+	var byteIndex uint32
+	var bitIndex uint32
+
+	width := rgbImage.Bounds().Dx()
+	height := rgbImage.Bounds().Dy()
+
+	var c color.NRGBA
+	var lsb byte
+
+	message = append(message, 0)
+
+	// iterate through every pixel in the image and stitch together the message bit by bit
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+
+			c = rgbImage.NRGBAAt(x, y) // get the color of the pixel
+
+			/*  RED  */
+			lsb = getLSB(c.R)                                                    // get the least significant bit from the red component of this pixel
+			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb) // add this bit to the message
+			bitIndex++
+
+			if bitIndex > 7 { // when we have filled up a byte, move on to the next byte
+				bitIndex = 0
+				byteIndex++
+
+				if byteIndex >= msgLen+startOffset {
+					return message[startOffset : msgLen+startOffset]
+				}
+
+				message = append(message, 0)
+			}
+
+			/*  GREEN  */
+			lsb = getLSB(c.G)
+			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb)
+			bitIndex++
+
+			if bitIndex > 7 {
+
+				bitIndex = 0
+				byteIndex++
+
+				if byteIndex >= msgLen+startOffset {
+					return message[startOffset : msgLen+startOffset]
+				}
+
+				message = append(message, 0)
+			}
+
+			/*  BLUE  */
+			lsb = getLSB(c.B)
+			message[byteIndex] = setBitInByte(message[byteIndex], bitIndex, lsb)
+			bitIndex++
+
+			if bitIndex > 7 {
+				bitIndex = 0
+				byteIndex++
+
+				if byteIndex >= msgLen+startOffset {
+					return message[startOffset : msgLen+startOffset]
+				}
+
+				message = append(message, 0)
+			}
+		}
+	}
+	return
+}
+
+// referenceNextBit feeds the bits of the byte array to the channel, as referenceEmbedNRGBA expects
+func referenceNextBit(byteArray []byte, ch chan byte) {
+
+	var offsetInBytes int
+	var offsetInBitsIntoByte int
+	var choiceByte byte
+
+	lenOfString := len(byteArray)
+
+	for {
+		if offsetInBytes >= lenOfString {
+			close(ch)
+			return
+		}
+
+		choiceByte = byteArray[offsetInBytes]
+		ch <- getBitFromByte(choiceByte, offsetInBitsIntoByte)
+
+		offsetInBitsIntoByte++
+
+		if offsetInBitsIntoByte >= 8 {
+			offsetInBitsIntoByte = 0
+			offsetInBytes++
+		}
+	}
+}
+
+func TestEmbedMatchesReference(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []image.Point{{1, 1}, {7, 3}, {64, 64}, {333, 101}} {
+		cover := image.NewNRGBA(image.Rectangle{image.Point{0, 0}, size})
+		random.Read(cover.Pix)
+		message := make([]byte, random.Intn(size.X*size.Y*3/8+1))
+		random.Read(message)
+
+		expected := imageToNRGBA(cover)
+		referenceEmbedNRGBA(expected, message)
+		embedNRGBA(cover, message)
+
+		if !bytes.Equal(cover.Pix, expected.Pix) {
+			log.Printf("embedded image differs from the reference implementation for size %v", size)
+			t.FailNow()
+		}
+
+		if len(message) > 3 {
+			msg := decodeNRGBA(3, uint32(len(message)-3), cover)
+			if !bytes.Equal(msg, referenceDecodeNRGBA(3, uint32(len(message)-3), expected)) || !bytes.Equal(msg, message[3:]) {
+				log.Printf("decoded message differs from the reference implementation for size %v", size)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+// newBenchmarkImage creates a multi-megapixel random image and a message filling it
+func newBenchmarkImage() (*image.NRGBA, []byte) {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 2000, 1500))
+	random.Read(img.Pix)
+	message := make([]byte, MaxEncodeSize(img))
+	random.Read(message)
+	return img, message
+}
+
+func BenchmarkEmbedNRGBA(b *testing.B) {
+	img, message := newBenchmarkImage()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		embedNRGBA(img, message)
+	}
+}
+
+func BenchmarkEmbedNRGBAReference(b *testing.B) {
+	img, message := newBenchmarkImage()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		referenceEmbedNRGBA(img, message)
+	}
+}
+
+func BenchmarkDecodeNRGBA(b *testing.B) {
+	img, message := newBenchmarkImage()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeNRGBA(0, uint32(len(message)), img)
+	}
+}
+
+func BenchmarkDecodeNRGBAReference(b *testing.B) {
+	img, message := newBenchmarkImage()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		referenceDecodeNRGBA(0, uint32(len(message)), img)
+	}
+}
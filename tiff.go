@@ -0,0 +1,315 @@
+package steganography
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+// TIFF files are read with golang.org/x/image/tiff, which registers its decoder with the image package, and written
+// uncompressed with it too. It can not write LZW compressed files, which are written here, in a single strip.
+
+// TIFFCompression selects the compression of the TIFF files written by the Encoder
+type TIFFCompression uint8
+
+const (
+	// TIFFUncompressed writes uncompressed TIFF files, the default
+	TIFFUncompressed TIFFCompression = iota
+	// TIFFLZW compresses TIFF files with LZW
+	TIFFLZW
+)
+
+// TIFF tags, field types and values
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffXResolution     = 282
+	tiffYResolution     = 283
+	tiffPlanarConfig    = 284
+	tiffResolutionUnit  = 296
+	tiffColorMap        = 320
+	tiffExtraSamples    = 338
+
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+
+	tiffLZW = 5 // compression
+
+	tiffBlackIsZero = 1 // photometric interpretation
+	tiffRGB         = 2
+	tiffPaletted    = 3
+
+	tiffAssociatedAlpha   = 1 // extra samples
+	tiffUnassociatedAlpha = 2
+)
+
+// writeTIFF writes the image as a TIFF file, compressed as selected by Options.TIFFCompression
+// The samples of 16-bit images are written with their full precision. Paletted images with translucent colors are refused
+// with ErrLossyFormat, as the color map has no alpha, and palettes of less than 256 colors with ErrUnsupportedFormat (see fullPalette).
+func writeTIFF(w io.Writer, img image.Image, opts Options) error {
+	if paletted, ok := img.(*image.Paletted); ok {
+		if !opaquePalette(paletted.Palette) {
+			return ErrLossyFormat
+		}
+		if len(paletted.Palette) != 256 {
+			return ErrUnsupportedFormat
+		}
+	}
+	if opts.TIFFCompression == TIFFLZW {
+		return writeLZWTIFF(w, img)
+	}
+	return tiff.Encode(w, img, nil)
+}
+
+// tiffLayout describes how the samples of an image are stored
+type tiffLayout struct {
+	width, height int
+	photometric   uint32
+	samples       int    // samples per pixel
+	bits          int    // bits per sample, 8 or 16
+	extra         uint32 // meaning of the fourth sample of RGB images
+}
+
+// writeLZWTIFF writes the image as a big endian TIFF file, LZW compressed in a single strip
+func writeLZWTIFF(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	l := tiffLayout{width: bounds.Dx(), height: bounds.Dy(), photometric: tiffRGB, samples: 4, bits: 8, extra: tiffUnassociatedAlpha}
+	var pix []byte
+	var stride, offset int
+	var palette color.Palette
+	opaque := true
+	switch src := img.(type) {
+	case *image.Gray:
+		l.photometric, l.samples = tiffBlackIsZero, 1
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	case *image.Gray16:
+		l.photometric, l.samples, l.bits = tiffBlackIsZero, 1, 16
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	case *image.Paletted:
+		l.photometric, l.samples, palette = tiffPaletted, 1, src.Palette
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	case *image.NRGBA64:
+		l.bits, opaque = 16, src.Opaque()
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	case *image.RGBA64:
+		l.bits, l.extra, opaque = 16, tiffAssociatedAlpha, src.Opaque()
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	case *image.RGBA:
+		l.extra, opaque = tiffAssociatedAlpha, src.Opaque()
+		pix, stride, offset = src.Pix, src.Stride, src.PixOffset(bounds.Min.X, bounds.Min.Y)
+	default:
+		rgbImage := imageToNRGBA(img)
+		opaque = rgbImage.Opaque()
+		pix, stride = rgbImage.Pix, rgbImage.Stride
+	}
+
+	// the samples are stored big endian like in the Pix slices, dropping the alpha channel of opaque images
+	channels := l.samples
+	if l.samples == 4 && opaque {
+		l.samples = 3
+	}
+	size := l.bits / 8
+	samples := make([]byte, 0, l.width*l.height*l.samples*size)
+	for y := 0; y < l.height; y++ {
+		row := pix[offset+y*stride:]
+		if l.samples == channels {
+			samples = append(samples, row[:l.width*channels*size]...)
+			continue
+		}
+		for x := 0; x < l.width; x++ {
+			samples = append(samples, row[x*channels*size:(x*channels+l.samples)*size]...)
+		}
+	}
+	samples = lzwEncode(samples)
+
+	bitsPerSample := make([]uint32, l.samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = uint32(l.bits)
+	}
+	entries := []tiffEntry{
+		{tiffImageWidth, tiffLong, []uint32{uint32(l.width)}},
+		{tiffImageLength, tiffLong, []uint32{uint32(l.height)}},
+		{tiffBitsPerSample, tiffShort, bitsPerSample},
+		{tiffCompression, tiffShort, []uint32{tiffLZW}},
+		{tiffPhotometric, tiffShort, []uint32{l.photometric}},
+		{tiffStripOffsets, tiffLong, []uint32{8}}, // the strip follows the header
+		{tiffSamplesPerPixel, tiffShort, []uint32{uint32(l.samples)}},
+		{tiffRowsPerStrip, tiffLong, []uint32{uint32(l.height)}},
+		{tiffStripByteCounts, tiffLong, []uint32{uint32(len(samples))}},
+		{tiffXResolution, tiffRational, []uint32{72, 1}},
+		{tiffYResolution, tiffRational, []uint32{72, 1}},
+		{tiffPlanarConfig, tiffShort, []uint32{1}},
+		{tiffResolutionUnit, tiffShort, []uint32{2}}, // inches
+	}
+	if palette != nil {
+		colorMap := make([]uint32, 3*256)
+		for i, c := range palette {
+			r, g, b, _ := c.RGBA()
+			colorMap[i], colorMap[256+i], colorMap[512+i] = r, g, b
+		}
+		entries = append(entries, tiffEntry{tiffColorMap, tiffShort, colorMap})
+	}
+	if l.samples == 4 {
+		entries = append(entries, tiffEntry{tiffExtraSamples, tiffShort, []uint32{l.extra}})
+	}
+
+	bw := bufio.NewWriter(w)
+	header := []byte("MM\x00\x2A\x00\x00\x00\x00")
+	ifdOffset := 8 + (len(samples)+1)/2*2 // the directory starts on a word boundary
+	binary.BigEndian.PutUint32(header[4:], uint32(ifdOffset))
+	bw.Write(header)
+	bw.Write(samples)
+	if len(samples)%2 != 0 {
+		bw.WriteByte(0)
+	}
+	bw.Write(marshalIFD(entries, ifdOffset))
+	return bw.Flush()
+}
+
+// tiffEntry is a field of an image file directory
+type tiffEntry struct {
+	tag    uint16
+	typ    uint16
+	values []uint32
+}
+
+// marshalIFD returns the image file directory holding the entries, sorted by tag, written at the given offset
+// Values not fitting in their entry follow the directory.
+func marshalIFD(entries []tiffEntry, offset int) []byte {
+	var ifd bytes.Buffer
+	var values []byte
+	valuesOffset := offset + 2 + 12*len(entries) + 4
+
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, uint16(len(entries)))
+	ifd.Write(b[:2])
+	for _, e := range entries {
+		var value []byte
+		for _, v := range e.values {
+			switch e.typ {
+			case tiffShort:
+				value = append(value, byte(v>>8), byte(v))
+			default: // longs, and the numerators and denominators of rationals
+				value = append(value, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+			}
+		}
+		count := len(e.values)
+		if e.typ == tiffRational {
+			count /= 2
+		}
+		binary.BigEndian.PutUint16(b, e.tag)
+		binary.BigEndian.PutUint16(b[2:], e.typ)
+		binary.BigEndian.PutUint32(b[4:], uint32(count))
+		for i := 8; i < 12; i++ {
+			b[i] = 0
+		}
+		if len(value) <= 4 {
+			copy(b[8:], value)
+		} else {
+			binary.BigEndian.PutUint32(b[8:], uint32(valuesOffset+len(values)))
+			values = append(values, value...)
+		}
+		ifd.Write(b)
+	}
+	ifd.Write([]byte{0, 0, 0, 0}) // no next directory
+	ifd.Write(values)
+	return ifd.Bytes()
+}
+
+// TIFF LZW codes are written most significant bit first, starting with 9 bits. Unlike compress/lzw, the code width grows
+// one code early, when the code table holds 2^width - 1 entries, as libtiff and all TIFF readers expect.
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxWidth = 12
+	lzwMaxCodes = 1<<lzwMaxWidth - 2 // the table is cleared before the width would exceed 12 bits
+)
+
+// lzwWriter writes LZW codes most significant bit first
+type lzwWriter struct {
+	out   []byte
+	bits  uint32
+	nbits int
+}
+
+func (w *lzwWriter) write(code, width int) {
+	w.bits = w.bits<<uint(width) | uint32(code)
+	w.nbits += width
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits>>uint(w.nbits-8)))
+		w.nbits -= 8
+	}
+}
+
+func (w *lzwWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits<<uint(8-w.nbits)))
+	}
+	return w.out
+}
+
+// lzwEncode compresses the data as a TIFF LZW strip
+func lzwEncode(data []byte) []byte {
+	w := new(lzwWriter)
+	table := make(map[uint32]int) // codes of the strings known, by prefix code and suffix byte
+	width, next := 9, lzwFirst
+	w.write(lzwClear, width)
+	if len(data) == 0 {
+		w.write(lzwEOI, width)
+		return w.flush()
+	}
+
+	// the decoder adds each code one code later than the encoder, so widths grow when the table reaches 2^width
+	// entries here, which is 2^width - 1 entries for the decoder
+	grow := func() {
+		next++
+		if next == 1<<uint(width) && width < lzwMaxWidth {
+			width++
+		}
+	}
+	current := int(data[0])
+	for _, b := range data[1:] {
+		key := uint32(current)<<8 | uint32(b)
+		if code, ok := table[key]; ok {
+			current = code
+			continue
+		}
+		w.write(current, width)
+		table[key] = next
+		grow()
+		if next == lzwMaxCodes {
+			w.write(lzwClear, width)
+			table = make(map[uint32]int)
+			width, next = 9, lzwFirst
+		}
+		current = int(b)
+	}
+	w.write(current, width)
+	grow()
+	w.write(lzwEOI, width)
+	return w.flush()
+}
+
+// EncodeTIFF encodes the message into the cover image, and writes the result as a TIFF file to w, compressed as selected
+// by Options.TIFFCompression. 16-bit images are written with 16 bits per sample.
+func (e *Encoder) EncodeTIFF(w io.Writer, cover image.Image, message []byte) error {
+	stego, err := e.Embed(cover, message)
+	if err != nil {
+		return err
+	}
+	return writeTIFF(w, stego, e.opts)
+}
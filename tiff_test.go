@@ -0,0 +1,123 @@
+package steganography
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+func TestTIFFRoundTrip(t *testing.T) {
+	rgb := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	copy(rgb.Pix, texturedImage(33, 17).Pix)
+	premultiplied := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	for i := range premultiplied.Pix {
+		premultiplied.Pix[i] = byte(i / 4 % 128) // no sample exceeds its alpha
+	}
+	translucent := texturedImage(33, 17)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = byte(i)
+	}
+	gray := image.NewGray(image.Rect(5, 5, 38, 22))
+	gray16 := image.NewGray16(image.Rect(0, 0, 33, 17))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * 13)
+	}
+	for i := range gray16.Pix {
+		gray16.Pix[i] = byte(i * 7)
+	}
+	rgb64 := image.NewRGBA64(image.Rect(0, 0, 33, 17))
+	for i := range rgb64.Pix {
+		rgb64.Pix[i] = byte(i * 11)
+		if i%8 >= 6 {
+			rgb64.Pix[i] = 0xff
+		}
+	}
+
+	images := []image.Image{rgb, premultiplied, translucent, gray, gray16, rgb64, newTestImage16(33, 17), newPalettedImage(33, 17, palette.Plan9)}
+	for _, img := range images {
+		for _, compression := range []TIFFCompression{TIFFUncompressed, TIFFLZW} {
+			var buf bytes.Buffer
+			if err := writeTIFF(&buf, img, Options{TIFFCompression: compression}); err != nil {
+				log.Printf("Error writing %T with compression %d: %v", img, compression, err)
+				t.FailNow()
+			}
+			decoded, format, err := image.Decode(&buf)
+			if err != nil || format != "tiff" {
+				log.Printf("%T decoded as %q (%v), expected tiff", img, format, err)
+				t.FailNow()
+			}
+			if fmt.Sprintf("%T", decoded) != fmt.Sprintf("%T", img) || !samePixels(img, decoded) {
+				log.Printf("%T with compression %d decoded to another %T", img, compression, decoded)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestLZW(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000) // fills the code table many times
+	for i := range random {
+		random[i] = byte(rng.Intn(256))
+	}
+	repeated := bytes.Repeat([]byte("abcabcabd"), 5000)
+
+	for _, data := range [][]byte{{}, {42}, random, repeated, append(bytes.Repeat([]byte{0}, 70000), 1)} {
+		decoded, err := ioutil.ReadAll(lzw.NewReader(bytes.NewReader(lzwEncode(data)), lzw.MSB, 8))
+		if err != nil {
+			log.Printf("Error decoding %d bytes: %v", len(data), err)
+			t.FailNow()
+		}
+		if !bytes.Equal(decoded, data) {
+			log.Printf("decoded data of %d bytes differs", len(data))
+			t.FailNow()
+		}
+	}
+	if size := len(lzwEncode(repeated)); size > len(repeated)/10 {
+		log.Printf("repeated data compressed to %d bytes", size)
+		t.FailNow()
+	}
+}
+
+func TestEncodeTIFF(t *testing.T) {
+	message := []byte("tagged image file")
+	var cover bytes.Buffer
+	if err := writeTIFF(&cover, newTestImage16(40, 30), Options{}); err != nil {
+		log.Printf("Error writing TIFF %v", err)
+		t.FailNow()
+	}
+
+	// TIFF covers are written back as TIFF by EncodeFile, keeping 16-bit samples
+	var out bytes.Buffer
+	opts := Options{Depth: 6, Key: []byte("key"), TIFFCompression: TIFFLZW}
+	if err := NewEncoder(WithOptions(opts)).EncodeFile(&out, bytes.NewReader(cover.Bytes()), message); err != nil {
+		log.Printf("Error encoding file %v", err)
+		t.FailNow()
+	}
+	stego, format, err := image.Decode(bytes.NewReader(out.Bytes()))
+	if _, ok := stego.(*image.NRGBA64); err != nil || format != "tiff" || !ok {
+		log.Printf("written as a %q %T (%v), expected a 16-bit tiff", format, stego, err)
+		t.FailNow()
+	}
+	if decoded, err := NewDecoder(WithOptions(opts)).DecodeFile(&out); err != nil || !bytes.Equal(decoded, message) {
+		log.Printf("decoded %q (%v), expected %q", decoded, err, message)
+		t.FailNow()
+	}
+
+	out.Reset()
+	if err := NewEncoder().EncodeTIFF(&out, newPalettedImage(8, 8, palette.WebSafe[:100]), []byte("m")); err != nil {
+		log.Printf("truecolor embedding of a paletted cover failed: %v", err)
+		t.FailNow()
+	}
+	if err := writeTIFF(&out, newPalettedImage(8, 8, palette.WebSafe[:100]), Options{}); err != ErrUnsupportedFormat {
+		log.Printf("expected ErrUnsupportedFormat writing a palette of 100 colors, got %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,83 @@
+package steganography
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// traversal yields the slots carrying a stream, in embedding order
+type traversal interface {
+	next() int
+}
+
+// sequentialOrder walks the slots in order
+type sequentialOrder struct {
+	i int
+}
+
+func (o *sequentialOrder) next() int {
+	o.i++
+	return o.i - 1
+}
+
+// permutation lazily yields a pseudo-random permutation of [0, n), seeded by a secret key
+// It runs a sparse Fisher-Yates shuffle driven by AES-CTR, so only the positions drawn so far are kept in memory.
+type permutation struct {
+	n       int
+	i       int
+	swapped map[int]int // positions of the virtual array that no longer hold their own index
+	stream  cipher.Stream
+	buf     [8]byte
+}
+
+// newPermutation creates the permutation of [0, n) derived from the key
+// The same key always yields the same order, which is what lets the decoder follow the encoder.
+func newPermutation(key []byte, n int) *permutation {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("steganography traversal"))
+	seed := mac.Sum(nil)
+
+	block, _ := aes.NewCipher(seed) // a 32 byte seed always makes a valid AES-256 key
+	return &permutation{
+		n:       n,
+		swapped: make(map[int]int),
+		stream:  cipher.NewCTR(block, make([]byte, aes.BlockSize)),
+	}
+}
+
+// next returns the next element of the permutation
+// It must not be called more than n times.
+func (p *permutation) next() int {
+	j := p.i + p.uniform(p.n-p.i)
+
+	value := p.at(j)
+	p.swapped[j] = p.at(p.i)
+	delete(p.swapped, p.i) // position i is never drawn again
+	p.i++
+	return value
+}
+
+// at returns the element currently held at position k of the virtual array
+func (p *permutation) at(k int) int {
+	if v, ok := p.swapped[k]; ok {
+		return v
+	}
+	return k
+}
+
+// uniform returns an unbiased random number in [0, n)
+func (p *permutation) uniform(n int) int {
+	max := ^uint64(0) - ^uint64(0)%uint64(n) // rejection threshold avoiding modulo bias
+	for {
+		for i := range p.buf {
+			p.buf[i] = 0
+		}
+		p.stream.XORKeyStream(p.buf[:], p.buf[:])
+		if v := binary.BigEndian.Uint64(p.buf[:]); v < max {
+			return int(v % uint64(n))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package steganography
+
+import (
+	"log"
+	"testing"
+)
+
+func TestPermutation(t *testing.T) {
+	n := 1000
+	seen := make([]bool, n)
+	order := newPermutation([]byte("key"), n)
+	sequential := true
+	for i := 0; i < n; i++ {
+		v := order.next()
+		if v < 0 || v >= n || seen[v] {
+			log.Printf("Invalid or repeated element %d at step %d", v, i)
+			t.FailNow()
+		}
+		seen[v] = true
+		sequential = sequential && v == i
+	}
+	if sequential {
+		log.Print("permutation is the identity")
+		t.FailNow()
+	}
+	if len(order.swapped) != 0 {
+		log.Printf("permutation leaked %d entries", len(order.swapped))
+		t.FailNow()
+	}
+}
+
+func TestPermutationDeterministic(t *testing.T) {
+	a := newPermutation([]byte("key"), 1<<20)
+	b := newPermutation([]byte("key"), 1<<20)
+	c := newPermutation([]byte("other key"), 1<<20)
+
+	same := true
+	for i := 0; i < 100; i++ {
+		va, vb, vc := a.next(), b.next(), c.next()
+		if va != vb {
+			log.Printf("permutations with the same key differ at step %d", i)
+			t.FailNow()
+		}
+		same = same && va == vc
+	}
+	if same {
+		log.Print("permutations with different keys match")
+		t.FailNow()
+	}
+}